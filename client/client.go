@@ -0,0 +1,480 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"io"
+	"log"
+	"sync"
+)
+
+var ErrServerSignature = errors.New("server signature invalid")
+var ErrNoSession = errors.New("no session ticket")
+
+// ErrPskDheKeyShare 开启PskDhe时服务端未返回key share
+var ErrPskDheKeyShare = errors.New("server key share missing")
+
+// ErrTicketRejected 服务端拒绝票据，early data未被处理
+var ErrTicketRejected = errors.New("ticket rejected by server")
+
+// Client 应用层客户端，ECDHE握手获取票据，之后用PSK 0-RTT发送请求
+// Request在无票据或票据即将过期时自动握手
+type Client interface {
+	Handshake() error
+	Request([]byte) ([]byte, error)
+	// RequestContext ctx取消时中止等待握手和收发，Transport实现ContextTransport时传入每次收发
+	RequestContext(ctx context.Context, data []byte) ([]byte, error)
+	RequestStream(body io.Reader) (io.ReadCloser, error)
+	NeedHandshake() bool // 无可用票据或票据已过期
+}
+
+// NewClient 按Config.CipherSuites选择密码套件创建客户端
+func NewClient(host string, config *Config) (Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	suite, err := config.cipherSuite()
+	if err != nil {
+		return nil, err
+	}
+	if suite == util.DHE_X25519_WITH_XSALSA20_POLY1305 {
+		return NewNaclClient(host, config)
+	}
+	return NewAesGcmClient(host, config)
+}
+
+// baseClient 各密码套件客户端共用的配置、票据和http收发
+type baseClient struct {
+	host         string
+	keyLogWriter io.Writer
+	identity     string
+	serverName   string
+	serverKey    ed25519.PublicKey
+	clock        util.Clock
+	rand         io.Reader
+	logger       *log.Logger
+	observer     util.Observer
+	transport    Transport
+	renewBefore  uint32
+	suite        uint8 // ECDHE密码套件
+	sessionCache ClientSessionCache
+	cacheKey     string
+	pskDhe       bool
+	retry        bool // 票据被拒绝时重新握手并重发
+
+	mu       sync.Mutex
+	state    clientState
+	inflight *flight // 进行中的握手
+}
+
+// clientState 票据状态，整体替换，读取时取快照
+type clientState struct {
+	ticketKey []byte
+	ticket    []byte
+	expireTs  uint32
+	gen       uint64        // 每次更新票据加一
+	reusable  bool          // 使用后服务端未下发新票据，可并发使用
+	busy      chan struct{} // 票据未确认可重复使用时由一个请求独占，结束时关闭
+}
+
+// flight 进行中的握手，其他调用方等待其结果
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+func newBaseClient(host string, config *Config, suite uint8) (*baseClient, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	c := &baseClient{
+		host:         host + config.Path,
+		keyLogWriter: config.KeyLogWriter,
+		identity:     config.Identity,
+		serverName:   config.ServerName,
+		serverKey:    config.ServerKey,
+		clock:        config.Clock,
+		rand:         config.Rand,
+		logger:       config.Logger,
+		observer:     config.Observer,
+		transport:    config.Transport,
+		renewBefore:  uint32(config.RenewBefore.Seconds()),
+		suite:        suite,
+		sessionCache: config.SessionCache,
+		cacheKey:     host + config.Path + "|" + config.ServerName,
+		pskDhe:       config.PskDhe,
+		retry:        config.RetryRejected,
+	}
+	if c.clock == nil {
+		c.clock = util.SystemClock
+	}
+	if c.rand == nil {
+		c.rand = rand.Reader
+	}
+	if config.RenewBefore == 0 {
+		c.renewBefore = uint32(DefaultRenewBefore.Seconds())
+	} else if config.RenewBefore < 0 {
+		c.renewBefore = 0
+	}
+	if c.transport == nil {
+		c.transport = &HTTPTransport{URL: c.host, Client: config.HTTPClient, MaxResponseSize: config.MaxResponseSize}
+	}
+	return c, nil
+}
+
+// NeedHandshake 无可用票据或票据即将过期
+func (c *baseClient) NeedHandshake() bool {
+	st := c.session()
+	return st.ticket == nil || st.expireTs <= uint32(c.clock.Now().Unix())+c.renewBefore
+}
+
+// session 当前票据状态快照
+func (c *baseClient) session() clientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// singleFlight 同一时间只有一个握手，其他调用方等待其结果，ctx取消时不再等待
+// 票据在gen之后已被更新时不再握手；握手使用发起方的ctx
+func (c *baseClient) singleFlight(ctx context.Context, gen uint64, handshake func(context.Context) error) error {
+	c.mu.Lock()
+	if f := c.inflight; f != nil {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if c.state.gen != gen {
+		c.mu.Unlock()
+		return nil
+	}
+	f := &flight{done: make(chan struct{})}
+	c.inflight = f
+	c.mu.Unlock()
+
+	f.err = handshake(ctx)
+	c.mu.Lock()
+	c.inflight = nil
+	c.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// do 按需握手后发送请求
+// 服务端以告警拒绝票据时清除票据，下次请求重新握手；拒绝告警未经认证，early data可能已被处理，
+// 只在配置了RetryRejected时重新握手后重发一次，其他错误不自动重试
+func (c *baseClient) do(ctx context.Context, handshake func(context.Context) error,
+	request func(context.Context, []byte) ([]byte, error), data []byte) ([]byte, error) {
+	c.loadSession()
+	gen := c.session().gen
+	if c.NeedHandshake() {
+		if err := c.singleFlight(ctx, gen, handshake); err != nil {
+			return nil, err
+		}
+		gen = c.session().gen
+	}
+	resp, err := request(ctx, data)
+	if errors.Is(err, ErrNoSession) {
+		// 等待独占的票据期间票据被拒绝清除，请求未发出，重新握手
+		if st := c.session(); st.ticket == nil {
+			if err = c.singleFlight(ctx, st.gen, handshake); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = request(ctx, data)
+	}
+	if !errors.Is(err, ErrTicketRejected) {
+		return resp, err
+	}
+	c.dropSession(gen)
+	if !c.retry {
+		return nil, err
+	}
+	if err = c.singleFlight(ctx, gen, handshake); err != nil {
+		return nil, err
+	}
+	return request(ctx, data)
+}
+
+// RetryRejected 票据被拒绝时Request自行重新握手重发，调用方不应再重发
+func (c *baseClient) RetryRejected() bool {
+	return c.retry
+}
+
+// acquire 票据未确认可重复使用时独占，其他请求等待其结束后使用新票据，避免并发请求使用同一张单次使用的票据，ctx取消时不再等待
+// 请求结束后调用release：响应未下发新票据则确认可重复使用，票据被拒绝则清除
+func (c *baseClient) acquire(ctx context.Context) (clientState, func(err error), error) {
+	c.mu.Lock()
+	for c.state.busy != nil {
+		busy := c.state.busy
+		c.mu.Unlock()
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return clientState{}, nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	st := c.state
+	if st.ticket == nil || st.reusable {
+		c.mu.Unlock()
+		return st, func(error) {}, nil
+	}
+	busy := make(chan struct{})
+	c.state.busy = busy
+	c.mu.Unlock()
+	return st, func(err error) {
+		c.mu.Lock()
+		if c.state.gen == st.gen {
+			c.state.busy = nil
+			c.state.reusable = err == nil
+			if errors.Is(err, ErrTicketRejected) {
+				c.state = clientState{gen: st.gen}
+			}
+		}
+		c.mu.Unlock()
+		close(busy)
+	}, nil
+}
+
+// dropSession 票据被拒绝时清除，期间票据已更新则保留
+func (c *baseClient) dropSession(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.gen != gen {
+		return
+	}
+	c.state = clientState{gen: gen}
+	if c.sessionCache != nil {
+		c.sessionCache.Delete(c.cacheKey)
+	}
+}
+
+// serverAlert 服务端告警转换为错误，非告警record返回nil
+func serverAlert(r recordData) error {
+	if r.Type() != record.TypeAlert {
+		return nil
+	}
+	if data := r.GetData(); len(data) == 1 && data[0] == record.AlertTicketRejected {
+		return ErrTicketRejected
+	}
+	return record.ErrAlert
+}
+
+// pskKeyShare 开启PskDhe时生成临时密钥并写入ClientHello，否则返回nil
+func (c *baseClient) pskKeyShare(clientHello helloMsg, suite uint8) (*util.KeyShare, error) {
+	if !c.pskDhe {
+		return nil, nil
+	}
+	share, err := util.NewKeyShare(c.rand, suite)
+	if err != nil {
+		return nil, err
+	}
+	clientHello.SetExtension(handshake.ExtKeyShare, share.Public)
+	return share, nil
+}
+
+// pskSecret 派生PSK响应密钥的secret，share为nil时即票据密钥，否则混入ECDHE共享密钥
+func pskSecret(share *util.KeyShare, ticketKey, serverHello []byte) ([]byte, error) {
+	if share == nil {
+		return ticketKey, nil
+	}
+	msg, err := handshake.Unmarshal(serverHello, handshake.TypServerHello)
+	if err != nil {
+		return nil, err
+	}
+	peer := msg.Extension(handshake.ExtKeyShare)
+	if peer == nil {
+		return nil, ErrPskDheKeyShare
+	}
+	shared, err := share.Shared(peer)
+	if err != nil {
+		return nil, err
+	}
+	return util.PskDheSecret(ticketKey, shared), nil
+}
+
+// handshakeKeys 握手完成后派生流量密钥所需的材料
+type handshakeKeys struct {
+	clientNonce   []byte
+	secret        []byte
+	transcript    []byte
+	connectionIDs []byte // 服务端分配的datagram connection ID
+}
+
+// helloMsg handshake.NewMsg返回的ClientHello
+type helloMsg interface {
+	SetExtension(typ uint8, data []byte)
+	Marshal(typ uint8) []byte
+}
+
+// recordData record.ReadNew返回的record
+type recordData interface {
+	Type() uint8
+	GetData() []byte
+	Marshal() []byte
+}
+
+// helloRecord 按密码套件选择record版本
+func helloRecord(suite uint8, data []byte) recordData {
+	if suite == util.DHE_X25519_WITH_XSALSA20_POLY1305 || suite == util.PSK_WITH_XSALSA20_POLY1305 {
+		return record.NewXsalsa20Poly1305(record.TypeHandshake, data)
+	}
+	return record.NewAesGcm(record.TypeHandshake, data)
+}
+
+// maxDuplicateRetry 回传cookie后最多忽略的HelloRetry数，datagram重传的ClientHello会得到重复的HelloRetry
+const maxDuplicateRetry = 3
+
+// exchangeHello 发送ClientHello并读取ServerHello record
+// 服务端要求回传cookie时，使用同一nonce重发一次ClientHello，之后收到的重复HelloRetry被忽略
+func (c *baseClient) exchangeHello(send func(hello []byte) (io.Reader, error), clientHello helloMsg, suite uint8) (
+	record0 recordData, serverRes io.Reader, record1 recordData, err error) {
+	if c.identity != "" {
+		clientHello.SetExtension(handshake.ExtIdentity, []byte(c.identity))
+	}
+	if c.serverName != "" {
+		clientHello.SetExtension(handshake.ExtServerName, []byte(c.serverName))
+	}
+	record0 = helloRecord(suite, clientHello.Marshal(handshake.TypClientHello))
+	for retried, duplicate := false, 0; ; {
+		if serverRes, err = send(record0.Marshal()); err != nil {
+			return
+		}
+		if record1, err = record.ReadNew(serverRes); err != nil {
+			return
+		}
+		if record1.Type() != record.TypeHandshake {
+			return nil, nil, nil, util.ErrDataCorrupted
+		}
+		retry, err := handshake.Unmarshal(record1.GetData(), handshake.TypHelloRetry)
+		if err != nil || duplicate == maxDuplicateRetry {
+			return record0, serverRes, record1, nil
+		}
+		if retried {
+			duplicate++
+			continue
+		}
+		retried = true
+		clientHello.SetExtension(handshake.ExtCookie, retry.Extension(handshake.ExtCookie))
+		record0 = helloRecord(suite, clientHello.Marshal(handshake.TypClientHello))
+	}
+}
+
+// verifyServer 配置了ServerKey时校验ServerHello签名
+func (c *baseClient) verifyServer(clientHello, serverKey, signature []byte) error {
+	if c.serverKey == nil {
+		return nil
+	}
+	if !ed25519.Verify(c.serverKey, util.SignedContent(clientHello, serverKey), signature) {
+		return ErrServerSignature
+	}
+	return nil
+}
+
+// setTicket 保存票据，data: [expireTs:4][ticket]
+func (c *baseClient) setTicket(ticketKey, data []byte) error {
+	if len(data) < 4 {
+		return util.ErrDataCorrupted
+	}
+	c.mu.Lock()
+	c.state = clientState{
+		ticketKey: ticketKey,
+		ticket:    data[4:],
+		expireTs:  binary.BigEndian.Uint32(data[:4]),
+		gen:       c.state.gen + 1,
+	}
+	st := c.state
+	c.mu.Unlock()
+	if c.sessionCache != nil {
+		err := c.sessionCache.Put(c.cacheKey, &ClientSession{
+			CipherSuite: c.suite,
+			ExpireTs:    st.expireTs,
+			TicketKey:   st.ticketKey,
+			Ticket:      st.ticket,
+		})
+		if err != nil && c.logger != nil {
+			c.logger.Printf("wdals: save session %q failed: %v", c.cacheKey, err)
+		}
+	}
+	return nil
+}
+
+// loadSession 无票据时从缓存读取，密码套件不一致或已过期的会话忽略
+func (c *baseClient) loadSession() {
+	if c.sessionCache == nil || c.session().ticket != nil {
+		return
+	}
+	session, err := c.sessionCache.Get(c.cacheKey)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) && c.logger != nil {
+			c.logger.Printf("wdals: load session %q failed: %v", c.cacheKey, err)
+		}
+		return
+	}
+	if session.CipherSuite != c.suite || session.ExpireTs <= uint32(c.clock.Now().Unix()) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.ticket == nil {
+		c.state = clientState{
+			ticketKey: session.TicketKey,
+			ticket:    session.Ticket,
+			expireTs:  session.ExpireTs,
+			gen:       c.state.gen + 1,
+		}
+	}
+}
+
+// exchange Transport实现ContextTransport时传入ctx，否则只在发送前检查ctx
+func (c *baseClient) exchange(ctx context.Context, data []byte, handshake bool) ([]byte, error) {
+	if t, ok := c.transport.(ContextTransport); ok {
+		return t.ExchangeContext(ctx, data, handshake)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.transport.Exchange(data, handshake)
+}
+
+func (c *baseClient) sendHello(ctx context.Context, hello []byte) (io.Reader, error) {
+	recv_data, err := c.exchange(ctx, hello, true)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(recv_data), nil
+}
+
+// post 发送PSK请求
+func (c *baseClient) post(ctx context.Context, payload []byte) (*bytes.Reader, error) {
+	recv_data, err := c.exchange(ctx, payload, false)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(recv_data), nil
+}
+
+// observe 记录握手事件，失败时输出日志
+func (c *baseClient) observe(event util.HandshakeEvent) {
+	if event.Err != nil && c.logger != nil {
+		c.logger.Printf("wdals: handshake cipher(%d) server name %q failed: %v", event.CipherSuite, event.ServerName, event.Err)
+	}
+	if c.observer != nil {
+		c.observer.ObserveHandshake(event)
+	}
+}
@@ -1,183 +1,147 @@
 package client
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdh"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
-	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"github.com/ryanx-sir/simple-als/handshake"
 	"github.com/ryanx-sir/simple-als/record"
 	"github.com/ryanx-sir/simple-als/util"
 	"golang.org/x/crypto/pbkdf2"
 	"io"
-	"log"
-	"net/http"
-	"time"
 )
 
 type aesGcmClient struct {
-	host                string
-	ticketKey           []byte
-	sessionTicket       []byte
-	sessionTicketExpire uint32
+	*baseClient
 }
 
-func NewAesGcmClient(host string) *aesGcmClient {
-	return &aesGcmClient{host: host}
+func NewAesGcmClient(host string, config *Config) (*aesGcmClient, error) {
+	base, err := newBaseClient(host, config, util.DHE_SECP256R1_WITH_AES_GCM)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGcmClient{baseClient: base}, nil
 }
 
 // Handshake
-// 1-rtt ecdhe
+// 1-rtt ecdhe，并发调用时共用同一次握手
 func (c *aesGcmClient) Handshake() error {
+	return c.singleFlight(context.Background(), c.session().gen, c.fullHandshake)
+}
+
+func (c *aesGcmClient) fullHandshake(ctx context.Context) (err error) {
+	start := c.clock.Now()
+	defer func() {
+		c.observe(util.HandshakeEvent{CipherSuite: util.DHE_SECP256R1_WITH_AES_GCM, ServerName: c.serverName,
+			Duration: c.clock.Now().Sub(start), Err: err})
+	}()
+	_, err = c.handshake(func(hello []byte) (io.Reader, error) {
+		return c.sendHello(ctx, hello)
+	}, false)
+	return
+}
+
+// handshake send发送ClientHello并返回服务端响应，connectionID为true时请求datagram connection ID
+func (c *aesGcmClient) handshake(send func(hello []byte) (io.Reader, error), connectionID bool) (_ *handshakeKeys, err error) {
 	cure := ecdh.P256()
-	privateKey, err := cure.GenerateKey(rand.Reader) // 客户端临时生成公、私密钥对
+	privateKey, err := util.GenerateP256Key(c.rand) // 客户端临时生成公、私密钥对
 	if err != nil {
-		return err
+		return nil, err
 	}
-	nowTs := time.Now().Unix()
+	nowTs := c.clock.Now().Unix()
 	hasher := sha256.New()
 
-	clientHello := handshake.NewMsg(uint32(nowTs), privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
-	record0 := record.NewAesGcm(record.TypeHandshake, clientHello.Marshal(handshake.TypClientHello))
-	hasher.Write(record0.GetData())
-
-	// todo 0. sendClientHello
-	resp, err := http.Get(c.host + "?hello=" + base64.RawURLEncoding.EncodeToString(record0.Marshal()))
+	clientHello, err := handshake.NewMsg(c.rand, uint32(nowTs), privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
 	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(resp.Status)
+		return nil, err
 	}
-	recv_data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if connectionID {
+		clientHello.SetExtension(handshake.ExtConnectionID, []byte{1})
 	}
-	resp.Body.Close()
-	serverRes := bytes.NewReader(recv_data)
-	var serverSeq uint32
 
+	// todo 0. sendClientHello
 	// todo 1. readServerHello
-	record1, err := record.ReadNew(serverRes)
+	record0, serverRes, record1, err := c.exchangeHello(send, clientHello, util.DHE_SECP256R1_WITH_AES_GCM)
 	if err != nil {
-		return err
-	}
-	if record1.Type() != record.TypeHandshake {
-		return util.ErrDataCorrupted
+		return nil, err
 	}
+	var serverSeq uint32
+	hasher.Write(record0.GetData())
 	hasher.Write(record1.GetData())
 	serverSeq++
 	serverHello, err := handshake.Unmarshal(record1.GetData(), handshake.TypServerHello)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if serverHello.CipherSuite() != util.DHE_SECP256R1_WITH_AES_GCM {
-		return errors.New("cipher not support")
+		return nil, errors.New("cipher not support")
+	}
+	if err = c.verifyServer(record0.GetData(), serverHello.CipherKey(), serverHello.Extension(handshake.ExtSignature)); err != nil {
+		return nil, err
 	}
 	// todo 2. keys kdf
 	publicKey, err := cure.NewPublicKey(serverHello.CipherKey())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	preSharedKey, _ := privateKey.ECDH(publicKey) // pre shared key
 	// 主密钥 = kdf(预主密钥+clientNonce+serverNonce)
 	masterKey := pbkdf2.Key(preSharedKey, append([]byte(util.MasterKdf),
 		hasher.Sum(nil)...), 1, 28, sha256.New) //[key:16+nonce:12]
-	log.Println("client", "masterKey", hex.EncodeToString(masterKey))
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogMaster, clientHello.Nonce(), masterKey)
 	ticketKey := pbkdf2.Key(preSharedKey, append([]byte(util.TicketKdf),
 		hasher.Sum(nil)...), 1, 32, sha256.New) //[key:16+nonce:12]
-	log.Println("client", "ticketKey", hex.EncodeToString(ticketKey))
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogTicket, clientHello.Nonce(), ticketKey)
 	keyPair := *(*[28]byte)(masterKey)
 
 	// todo 3. readNewSessionTicket
 	record2, err := record.ReadNew(serverRes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err = record2.AesGcmDecrypt(keyPair, serverSeq); err != nil {
-		return err
-	}
-	c.ticketKey = ticketKey
-	c.sessionTicketExpire = binary.BigEndian.Uint32(record2.GetData()[:4])
-	c.sessionTicket = record2.GetData()[4:]
-	return nil
-}
-
-// Request
-// 0-RTT PSK
-func (c *aesGcmClient) Request(data []byte) (_ []byte, err error) {
-	nowTs := time.Now().Unix()
-	var clientSeq, serverSeq uint32
-	hasher := sha256.New()
-
-	clientHello := handshake.NewMsg(uint32(nowTs), c.sessionTicket, util.PSK_WITH_AES_GCM)
-	record1 := record.NewAesGcm(record.TypeHandshake, clientHello.Marshal(handshake.TypClientHello))
-	hasher.Write(record1.GetData())
-	clientSeq++
-
-	//earlyKey = kdf(ticketKey+clientNonce)
-	earlyKey := pbkdf2.Key(c.ticketKey, append([]byte(util.EarlyKdf),
-		hasher.Sum(nil)...), 1, 28, sha256.New) //[key:16+nonce:12]
-	if err != nil {
-		return
-	}
-	log.Println("client", "earlyKey", hex.EncodeToString(earlyKey))
-	keyPair := *(*[28]byte)(earlyKey)
-
-	record2 := record.NewAesGcm(record.TypeApplicationData, data)
-	err = record2.AesGcmEncrypt(keyPair, clientSeq)
-	if err != nil {
-		return
-	}
-	clientSeq++
-
-	//
-	payload := append(record1.Marshal(), record2.Marshal()...)
-	log.Println("client", "payload", hex.EncodeToString(payload))
-
-	resp, err := http.Post(c.host, "application/x-wdals", bytes.NewReader(payload))
-	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(resp.Status)
-	}
-	recv_data, err := io.ReadAll(resp.Body)
-	if err != nil {
+	if err = c.setTicket(ticketKey, record2.GetData()); err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
-	log.Println("client", "recv_data", hex.EncodeToString(recv_data))
+	hasher.Write(record2.GetData())
+	serverSeq++
 
-	serverRes := bytes.NewReader(recv_data)
+	// todo 4. readConnectionIDs 服务端分配了connection ID时，ID列表在票据之后加密下发
+	var connectionIDs []byte
+	if connectionID && serverHello.Extension(handshake.ExtConnectionID) != nil {
+		record3, err := record.ReadNew(serverRes)
+		if err != nil {
+			return nil, err
+		}
+		if err = record3.AesGcmDecrypt(keyPair, serverSeq); err != nil {
+			return nil, err
+		}
+		data := record3.GetData()
+		if len(data) < 1 || data[0] != handshake.TypConnectionIDs {
+			return nil, util.ErrDataCorrupted
+		}
+		hasher.Write(data)
+		connectionIDs = data[1:]
+	}
+	return &handshakeKeys{clientNonce: clientHello.Nonce(), secret: preSharedKey, transcript: hasher.Sum(nil),
+		connectionIDs: connectionIDs}, nil
+}
 
-	// todo 1. readServerHello
-	record3, err := record.ReadNew(serverRes)
-	if err != nil {
-		return nil, err
-	}
-	hasher.Write(record3.GetData())
-	serverSeq++
+// Request
+// 0-RTT PSK，按需握手，票据被拒绝时返回ErrTicketRejected，配置了RetryRejected时重新握手后重发一次
+func (c *aesGcmClient) Request(data []byte) ([]byte, error) {
+	return c.do(context.Background(), c.fullHandshake, c.resume, data)
+}
 
-	// todo 2.readServerData
-	masterKey := pbkdf2.Key(c.ticketKey, append([]byte(util.MasterKdf),
-		hasher.Sum(nil)...), 1, 28, sha256.New) //[key:16+nonce:12]
-	if err != nil {
-		return
-	}
-	log.Println("client", "masterKey", hex.EncodeToString(masterKey))
-	keyPair = *(*[28]byte)(masterKey)
+// RequestContext 同Request，ctx取消时中止等待握手和收发
+func (c *aesGcmClient) RequestContext(ctx context.Context, data []byte) ([]byte, error) {
+	return c.do(ctx, c.fullHandshake, c.resume, data)
+}
 
-	dataRecord, err := record.ReadNew(serverRes)
-	if err != nil {
-		return nil, err
-	}
-	if err = dataRecord.AesGcmDecrypt(keyPair, serverSeq); err != nil {
-		return nil, err
-	}
-	return dataRecord.GetData(), nil
+// RequestStream 流式0-RTT PSK请求，响应读到服务端close_notify为止
+func (c *aesGcmClient) RequestStream(body io.Reader) (io.ReadCloser, error) {
+	return c.requestStream(c.fullHandshake, body)
 }
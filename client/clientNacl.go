@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+)
+
+// naclClient DHE_X25519_WITH_XSALSA20_POLY1305握手，PSK_WITH_XSALSA20_POLY1305恢复
+type naclClient struct {
+	*baseClient
+}
+
+func NewNaclClient(host string, config *Config) (*naclClient, error) {
+	base, err := newBaseClient(host, config, util.DHE_X25519_WITH_XSALSA20_POLY1305)
+	if err != nil {
+		return nil, err
+	}
+	return &naclClient{baseClient: base}, nil
+}
+
+// Handshake
+// 1-rtt ecdhe，并发调用时共用同一次握手
+func (c *naclClient) Handshake() error {
+	return c.singleFlight(context.Background(), c.session().gen, c.fullHandshake)
+}
+
+func (c *naclClient) fullHandshake(ctx context.Context) (err error) {
+	start := c.clock.Now()
+	defer func() {
+		c.observe(util.HandshakeEvent{CipherSuite: util.DHE_X25519_WITH_XSALSA20_POLY1305, ServerName: c.serverName,
+			Duration: c.clock.Now().Sub(start), Err: err})
+	}()
+	return c.handshake(func(hello []byte) (io.Reader, error) {
+		return c.sendHello(ctx, hello)
+	})
+}
+
+func (c *naclClient) handshake(send func(hello []byte) (io.Reader, error)) (err error) {
+	publicKey, privateKey, err := util.GenerateX25519Key(c.rand) // 客户端临时生成公、私密钥对
+	if err != nil {
+		return err
+	}
+	nowTs := c.clock.Now().Unix()
+	hasher := sha256.New()
+
+	clientHello, err := handshake.NewMsg(c.rand, uint32(nowTs), publicKey[:], util.DHE_X25519_WITH_XSALSA20_POLY1305)
+	if err != nil {
+		return err
+	}
+
+	// todo 0. sendClientHello
+	// todo 1. readServerHello
+	record0, serverRes, record1, err := c.exchangeHello(send, clientHello, util.DHE_X25519_WITH_XSALSA20_POLY1305)
+	if err != nil {
+		return err
+	}
+	hasher.Write(record0.GetData())
+	hasher.Write(record1.GetData())
+	serverHello, err := handshake.Unmarshal(record1.GetData(), handshake.TypServerHello)
+	if err != nil {
+		return err
+	}
+	if serverHello.CipherSuite() != util.DHE_X25519_WITH_XSALSA20_POLY1305 || len(serverHello.CipherKey()) != 32 {
+		return errors.New("cipher not support")
+	}
+	if err = c.verifyServer(record0.GetData(), serverHello.CipherKey(), serverHello.Extension(handshake.ExtSignature)); err != nil {
+		return err
+	}
+
+	// todo 2. keys kdf
+	preSharedKey, err := curve25519.X25519(privateKey[:], serverHello.CipherKey()) // pre shared key
+	if err != nil {
+		return err
+	}
+	masterKey := pbkdf2.Key(preSharedKey, append([]byte(util.MasterKdf),
+		hasher.Sum(nil)...), 1, 24, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogMaster, clientHello.Nonce(), masterKey)
+	ticketKey := pbkdf2.Key(preSharedKey, append([]byte(util.TicketKdf),
+		hasher.Sum(nil)...), 1, 32, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogTicket, clientHello.Nonce(), ticketKey)
+
+	// todo 3. readNewSessionTicket
+	record2, err := record.ReadNew(serverRes)
+	if err != nil {
+		return err
+	}
+	err = record2.NaclUnbox((*[24]byte)(masterKey), (*[32]byte)(serverHello.CipherKey()), privateKey)
+	if err != nil {
+		return err
+	}
+	return c.setTicket(ticketKey, record2.GetData())
+}
+
+// Request
+// 0-RTT PSK，按需握手，票据被拒绝时返回ErrTicketRejected，配置了RetryRejected时重新握手后重发一次
+func (c *naclClient) Request(data []byte) ([]byte, error) {
+	return c.do(context.Background(), c.fullHandshake, c.resume, data)
+}
+
+// RequestContext 同Request，ctx取消时中止等待握手和收发
+func (c *naclClient) RequestContext(ctx context.Context, data []byte) ([]byte, error) {
+	return c.do(ctx, c.fullHandshake, c.resume, data)
+}
+
+// RequestStream 流式0-RTT PSK请求，响应读到服务端close_notify为止
+func (c *naclClient) RequestStream(body io.Reader) (io.ReadCloser, error) {
+	return c.requestStream(c.fullHandshake, body)
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/server"
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+type countTransport struct {
+	Transport
+	handshakes int32
+}
+
+func (t *countTransport) Exchange(data []byte, handshake bool) ([]byte, error) {
+	if handshake {
+		atomic.AddInt32(&t.handshakes, 1)
+	}
+	return t.Transport.Exchange(data, handshake)
+}
+
+// delayTransport 延迟响应，使并发请求同时在途
+type delayTransport struct {
+	Transport
+	delay time.Duration
+}
+
+func (t *delayTransport) Exchange(data []byte, handshake bool) ([]byte, error) {
+	time.Sleep(t.delay)
+	return t.Transport.Exchange(data, handshake)
+}
+
+func Test_ClientSession(t *testing.T) {
+	encoder := ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}})
+	srv, err := server.NewServer(&server.Config{TicketEncoder: encoder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countTransport{Transport: &MemoryTransport{Server: srv}}
+	for _, suite := range []uint8{util.DHE_SECP256R1_WITH_AES_GCM, util.DHE_X25519_WITH_XSALSA20_POLY1305} {
+		transport.handshakes = 0
+		c, err := NewClient("", &Config{CipherSuites: []uint8{suite}, Transport: transport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// todo 1. 首次请求自动握手，之后复用票据
+		for i := 0; i < 2; i++ {
+			if _, err = c.Request([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if transport.handshakes != 1 {
+			t.Fatal("want 1 handshake", transport.handshakes)
+		}
+		// todo 2. 票据密钥轮换退役后，服务端拒绝票据，客户端清除票据，下次请求重新握手
+		if _, err = encoder.Rotate(rand.Reader, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Request([]byte("ping")); !errors.Is(err, ErrTicketRejected) {
+			t.Fatal("want ticket rejected", err)
+		}
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if transport.handshakes != 2 {
+			t.Fatal("want re-handshake", transport.handshakes)
+		}
+		// todo 3. RetryRejected时重新握手并重发
+		c, err = NewClient("", &Config{CipherSuites: []uint8{suite}, Transport: transport, RetryRejected: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = encoder.Rotate(rand.Reader, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if transport.handshakes != 4 {
+			t.Fatal("want retry handshake", transport.handshakes)
+		}
+	}
+}
+
+func Test_ConcurrentRequest(t *testing.T) {
+	srv, err := server.NewServer(&server.Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countTransport{Transport: &MemoryTransport{Server: srv}}
+	c, err := NewClient("", &Config{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Request([]byte("ping")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if transport.handshakes != 1 {
+		t.Fatal("want single handshake", transport.handshakes)
+	}
+}
+
+func Test_ConcurrentSingleUse(t *testing.T) {
+	srv, err := server.NewServer(&server.Config{
+		TicketEncoder:   ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		SingleUseTicket: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countTransport{Transport: &delayTransport{Transport: &MemoryTransport{Server: srv}, delay: time.Millisecond}}
+	c, err := NewClient("", &Config{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Request([]byte("ping")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if transport.handshakes != 1 {
+		t.Fatal("want single handshake", transport.handshakes)
+	}
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func Test_TicketRenewal(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	srv, err := server.NewServer(&server.Config{
+		TicketEncoder:     ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		TicketRenewBefore: 30 * time.Minute,
+		Clock:             clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countTransport{Transport: &MemoryTransport{Server: srv}}
+	c, err := NewAesGcmClient("", &Config{Transport: transport, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每40分钟请求一次，票据有效期1小时，靠PSK响应中的新票据续期
+	for i := 0; i < 4; i++ {
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(i, err)
+		}
+		if want := uint32(clock.now.Add(time.Hour).Unix()); c.session().expireTs != want {
+			t.Fatal(i, "unexpected expire", c.session().expireTs, want)
+		}
+		clock.now = clock.now.Add(40 * time.Minute)
+	}
+	if transport.handshakes != 1 {
+		t.Fatal("want single handshake", transport.handshakes)
+	}
+}
+
+// truncateTransport 去掉请求或响应末尾的record
+type truncateTransport struct {
+	Transport
+	request, response bool
+}
+
+// dropLastRecord 按record边界去掉最后一个record
+func dropLastRecord(data []byte) []byte {
+	var records [][]byte
+	for r := bytes.NewReader(data); r.Len() > 0; {
+		start := len(data) - r.Len()
+		if _, err := record.ReadNew(r); err != nil {
+			break
+		}
+		records = append(records, data[start:len(data)-r.Len()])
+	}
+	return bytes.Join(records[:len(records)-1], nil)
+}
+
+func (t *truncateTransport) Exchange(data []byte, handshake bool) ([]byte, error) {
+	if !handshake && t.request {
+		data = dropLastRecord(data)
+	}
+	resp, err := t.Transport.Exchange(data, handshake)
+	if err == nil && !handshake && t.response {
+		resp = dropLastRecord(resp)
+	}
+	return resp, err
+}
+
+func Test_RequestTruncated(t *testing.T) {
+	var got int
+	srv, err := server.NewServer(&server.Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		Handler: server.HandlerFunc(func(req *server.Request) ([]byte, error) {
+			got = len(req.Data)
+			return req.Data, nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 40000)
+	for _, tr := range []*truncateTransport{{request: true}, {response: true}} {
+		got = 0
+		tr.Transport = &MemoryTransport{Server: srv}
+		c, err := NewAesGcmClient("", &Config{Transport: tr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		// 截断的请求不交给Handler，截断的响应返回io.ErrUnexpectedEOF
+		resp, err := c.Request(data)
+		if tr.request && (err == nil || got != 0) {
+			t.Fatal("truncated request accepted", got, err)
+		}
+		if tr.response && (!errors.Is(err, io.ErrUnexpectedEOF) || resp != nil) {
+			t.Fatal("truncated response accepted", len(resp), err)
+		}
+	}
+}
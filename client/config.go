@@ -0,0 +1,97 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// DefaultMaxResponseSize 默认服务端响应上限
+const DefaultMaxResponseSize = 4 << 20
+
+// DefaultRenewBefore 票据剩余有效期小于该值时提前握手
+const DefaultRenewBefore = time.Minute
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Config 客户端配置，零值字段使用默认值，构造客户端时校验
+type Config struct {
+	// KeyLogWriter 调试用，按NSS key log格式输出会话密钥以便解密抓包
+	// 会泄露会话密钥，生产环境不要设置
+	KeyLogWriter io.Writer
+
+	// Identity 客户端声明的身份，未经认证，服务端未配置TicketIdentity时写入票据用于按身份吊销
+	Identity string
+
+	// ServerName 租户标识，服务端据此选择票据密钥和handler
+	ServerName string
+	// ServerKey 服务端身份公钥，不为nil时校验ServerHello签名
+	ServerKey ed25519.PublicKey
+
+	// CipherSuites ECDHE密码套件，按偏好排序，默认DHE_SECP256R1_WITH_AES_GCM
+	CipherSuites []uint8
+
+	Clock    util.Clock    // 默认系统时间
+	Rand     io.Reader     // 默认crypto/rand
+	Logger   *log.Logger   // 握手失败日志，不输出密钥，默认不输出
+	Observer util.Observer // 握手事件回调
+
+	// Transport 协议数据收发，为nil时使用HTTPTransport
+	Transport Transport
+	// HTTPClient 默认30秒超时的http.Client，Transport为nil时生效
+	HTTPClient *http.Client
+	// Path endpoint路径，拼接在host之后
+	Path string
+	// MaxResponseSize 服务端响应上限，默认4MB，Transport为nil时生效
+	MaxResponseSize int
+
+	// RenewBefore 票据剩余有效期小于该值时Request先重新握手，默认1分钟，负数表示到期才握手
+	RenewBefore time.Duration
+
+	// SessionCache 不为nil时Request前读取已保存的会话，获得新票据后保存
+	SessionCache ClientSessionCache
+
+	// PskDhe PSK请求携带临时公钥，响应和新票据密钥混入ECDHE共享密钥，票据密钥泄露后响应仍不可解密
+	PskDhe bool
+
+	// RetryRejected 票据被拒绝时Request完整握手后自动重发一次，默认返回ErrTicketRejected由调用方决定
+	// 拒绝告警是明文未经认证的，伪造告警可使请求被服务端处理两次，只在请求幂等时开启
+	RetryRejected bool
+}
+
+// supportedSuites 当前客户端实现的ECDHE密码套件
+var supportedSuites = []uint8{util.DHE_SECP256R1_WITH_AES_GCM, util.DHE_X25519_WITH_XSALSA20_POLY1305}
+
+func (c *Config) validate() error {
+	if c.ServerKey != nil && len(c.ServerKey) != ed25519.PublicKeySize {
+		return errors.New("client config: invalid ServerKey")
+	}
+	if c.MaxResponseSize < 0 {
+		return errors.New("client config: negative MaxResponseSize")
+	}
+	if _, err := c.cipherSuite(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cipherSuite 按偏好选择第一个支持的ECDHE密码套件
+func (c *Config) cipherSuite() (uint8, error) {
+	if len(c.CipherSuites) == 0 {
+		return supportedSuites[0], nil
+	}
+	for _, suite := range c.CipherSuites {
+		for _, supported := range supportedSuites {
+			if suite == supported {
+				return suite, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("client config: cipher suites %v not support", c.CipherSuites)
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"crypto/sha256"
+	"io"
+	"net"
+
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Conn 在流式连接上建立长连接会话，有可用票据时PSK恢复，无票据或票据被拒绝时完整握手
+func (c *aesGcmClient) Conn(nc net.Conn) (*conn.Conn, error) {
+	c.loadSession()
+	if !c.NeedHandshake() {
+		cc, err := c.ResumeConn(nc)
+		if err != ErrTicketRejected {
+			return cc, err
+		}
+	}
+	return c.HandshakeConn(nc)
+}
+
+// HandshakeConn 在流式连接上完成客户端握手，返回加密连接
+func (c *aesGcmClient) HandshakeConn(nc net.Conn) (*conn.Conn, error) {
+	keys, err := c.handshake(func(hello []byte) (io.Reader, error) {
+		_, err := nc.Write(hello)
+		return nc, err
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, serverKey := conn.TrafficKeys(record.ProtocolAesGcm, keys.secret, keys.transcript)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogClientTraffic, keys.clientNonce, clientKey)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogServerTraffic, keys.clientNonce, serverKey)
+	return conn.New(nc, record.ProtocolAesGcm, serverKey, clientKey), nil
+}
+
+// ResumeConn 在流式连接上用票据恢复会话，不发送early data
+// 票据被拒绝时返回ErrTicketRejected并清除票据，连接仍可用于完整握手
+func (c *baseClient) ResumeConn(nc net.Conn) (_ *conn.Conn, err error) {
+	suite := util.ResumptionSuite(c.suite)
+	start := c.clock.Now()
+	defer func() {
+		c.observe(util.HandshakeEvent{CipherSuite: suite, ServerName: c.serverName, Resumed: true,
+			Duration: c.clock.Now().Sub(start), Err: err})
+	}()
+	st := c.session()
+	if st.ticket == nil {
+		return nil, ErrNoSession
+	}
+	version := record.SuiteVersion(suite)
+	hasher := sha256.New()
+
+	clientHello, err := handshake.NewMsg(c.rand, uint32(c.clock.Now().Unix()), st.ticket, suite)
+	if err != nil {
+		return nil, err
+	}
+	if c.serverName != "" {
+		clientHello.SetExtension(handshake.ExtServerName, []byte(c.serverName))
+	}
+	share, err := c.pskKeyShare(clientHello, suite)
+	if err != nil {
+		return nil, err
+	}
+
+	// todo 0. sendClientHello
+	record1 := record.New(record.TypeHandshake, version, clientHello.Marshal(handshake.TypClientHello))
+	hasher.Write(record1.GetData())
+	if _, err = nc.Write(record1.Marshal()); err != nil {
+		return nil, err
+	}
+
+	// todo 1. readServerHello
+	record2, err := record.ReadNew(nc)
+	if err == nil {
+		err = serverAlert(record2)
+	}
+	if err == nil && record2.Type() != record.TypeHandshake {
+		err = util.ErrDataCorrupted
+	}
+	if err != nil {
+		if err == ErrTicketRejected {
+			c.dropSession(st.gen)
+		}
+		return nil, err
+	}
+	hasher.Write(record2.GetData())
+	transcript := hasher.Sum(nil)
+
+	// todo 2. keys kdf
+	secret, err := pskSecret(share, st.ticketKey, record2.GetData())
+	if err != nil {
+		return nil, err
+	}
+	clientKey, serverKey := conn.TrafficKeys(version, secret, transcript)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogClientTraffic, clientHello.Nonce(), clientKey)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogServerTraffic, clientHello.Nonce(), serverKey)
+	cc := conn.New(nc, version, serverKey, clientKey)
+
+	// todo 3. readNewSessionTicket 服务端可能在连接上下发新票据，首次Read时处理
+	cc.HandleHandshake(func(ticketData []byte) error {
+		if len(ticketData) < 5 || ticketData[0] != handshake.TypNewSessionTicket {
+			return util.ErrDataCorrupted
+		}
+		ticketKey := pbkdf2.Key(secret, append([]byte(util.TicketKdf),
+			transcript...), 1, 32, sha256.New)
+		util.WriteKeyLog(c.keyLogWriter, util.KeyLogTicket, clientHello.Nonce(), ticketKey)
+		return c.setTicket(ticketKey, ticketData[1:])
+	})
+	return cc, nil
+}
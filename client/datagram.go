@@ -0,0 +1,35 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// HandshakeDatagram 在datagram连接上完成客户端握手，nc的每次Read返回一个datagram
+// 超时未收到服务端响应时重传ClientHello，服务端分配了connection ID时可用Migrate切换地址
+func (c *aesGcmClient) HandshakeDatagram(nc net.Conn) (*conn.Datagram, error) {
+	flights := conn.NewFlightConn(nc, record.ProtocolAesGcm, c.rand)
+	keys, err := c.handshake(func(hello []byte) (io.Reader, error) {
+		flight, err := flights.Exchange(hello)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(flight), nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, serverKey := conn.TrafficKeys(record.ProtocolAesGcm, keys.secret, keys.transcript)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogClientTraffic, keys.clientNonce, clientKey)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogServerTraffic, keys.clientNonce, serverKey)
+	d := conn.NewDatagram(nc, record.ProtocolAesGcm, c.rand, serverKey, clientKey)
+	if err = d.SetConnectionIDs(keys.connectionIDs); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func (c *baseClient) resume(ctx context.Context, data []byte) (resp []byte, err error) {
+	start := c.clock.Now()
+	defer func() {
+		c.observe(util.HandshakeEvent{CipherSuite: util.ResumptionSuite(c.suite), ServerName: c.serverName, Resumed: true,
+			Duration: c.clock.Now().Sub(start), Err: err})
+	}()
+	return c.request(ctx, data)
+}
+
+// request 0-RTT PSK请求，PSK_WITH_AES_GCM、PSK_WITH_XSALSA20_POLY1305共用，按套件选择record版本和密钥长度
+func (c *baseClient) request(ctx context.Context, data []byte) (_ []byte, err error) {
+	st, release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { release(err) }()
+	if st.ticket == nil {
+		return nil, ErrNoSession
+	}
+	suite := util.ResumptionSuite(c.suite)
+	version := record.SuiteVersion(suite)
+	keySize := record.KeySize(version) // aes-gcm [key:16+nonce:12]，xsalsa20-poly1305 [key:32+nonce:24]
+	nowTs := c.clock.Now().Unix()
+	var clientSeq, serverSeq uint32
+	hasher := sha256.New()
+
+	clientHello, err := handshake.NewMsg(c.rand, uint32(nowTs), st.ticket, suite)
+	if err != nil {
+		return nil, err
+	}
+	if c.serverName != "" {
+		clientHello.SetExtension(handshake.ExtServerName, []byte(c.serverName))
+	}
+	share, err := c.pskKeyShare(clientHello, suite)
+	if err != nil {
+		return nil, err
+	}
+	record1 := record.New(record.TypeHandshake, version, clientHello.Marshal(handshake.TypClientHello))
+	hasher.Write(record1.GetData())
+	clientSeq++
+
+	//earlyKey = kdf(ticketKey+clientNonce)
+	earlyKey := pbkdf2.Key(st.ticketKey, append([]byte(util.EarlyKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogEarly, clientHello.Nonce(), earlyKey)
+
+	// 请求以加密的close_notify结束，服务端据此检测截断
+	var payload bytes.Buffer
+	w := record.NewWriter(&payload, version, earlyKey, clientSeq)
+	w.Header = record1.Marshal()
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	serverRes, err := c.post(ctx, payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// todo 1. readServerHello
+	record3, err := record.ReadNew(serverRes)
+	if err != nil {
+		return nil, err
+	}
+	if err = serverAlert(record3); err != nil {
+		return nil, err
+	}
+	hasher.Write(record3.GetData())
+	serverSeq++
+	secret, err := pskSecret(share, st.ticketKey, record3.GetData())
+	if err != nil {
+		return nil, err
+	}
+
+	// todo 2.readServerData
+	masterKey := pbkdf2.Key(secret, append([]byte(util.MasterKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogMaster, clientHello.Nonce(), masterKey)
+
+	// 读到服务端加密的close_notify为止，被截断时返回io.ErrUnexpectedEOF
+	reader := record.NewReader(serverRes, masterKey, serverSeq)
+	reader.OnHandshake = func(ticketData []byte) error {
+		// todo 3. readNewSessionTicket 服务端下发的新票据
+		if len(ticketData) < 5 || ticketData[0] != handshake.TypNewSessionTicket {
+			return util.ErrDataCorrupted
+		}
+		ticketKey := pbkdf2.Key(secret, append([]byte(util.TicketKdf),
+			hasher.Sum(nil)...), 1, 32, sha256.New)
+		util.WriteKeyLog(c.keyLogWriter, util.KeyLogTicket, clientHello.Nonce(), ticketKey)
+		return c.setTicket(ticketKey, ticketData[1:])
+	}
+	respData, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return respData, nil
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var ErrSessionNotFound = errors.New("client session not found")
+var ErrSessionCorrupted = errors.New("client session corrupted")
+
+// ClientSession 可持久化的客户端会话状态
+type ClientSession struct {
+	CipherSuite uint8 // 签发票据的ECDHE密码套件
+	ExpireTs    uint32
+	TicketKey   []byte
+	Ticket      []byte
+}
+
+// clientSessionVersion 序列化格式版本
+const clientSessionVersion uint8 = 1
+
+// MarshalBinary [version:1][suite:1][expireTs:4][ticketKeyLen:1][ticketKey][ticket]
+func (s *ClientSession) MarshalBinary() ([]byte, error) {
+	if len(s.TicketKey) > 0xff {
+		return nil, ErrSessionCorrupted
+	}
+	data := make([]byte, 0, 7+len(s.TicketKey)+len(s.Ticket))
+	data = append(data, clientSessionVersion, s.CipherSuite)
+	data = binary.BigEndian.AppendUint32(data, s.ExpireTs)
+	data = append(data, uint8(len(s.TicketKey)))
+	data = append(data, s.TicketKey...)
+	return append(data, s.Ticket...), nil
+}
+
+func (s *ClientSession) UnmarshalBinary(data []byte) error {
+	if len(data) < 7 || data[0] != clientSessionVersion {
+		return ErrSessionCorrupted
+	}
+	keyLen := int(data[6])
+	if len(data) < 7+keyLen {
+		return ErrSessionCorrupted
+	}
+	s.CipherSuite = data[1]
+	s.ExpireTs = binary.BigEndian.Uint32(data[2:])
+	s.TicketKey = append([]byte(nil), data[7:7+keyLen]...)
+	s.Ticket = append([]byte(nil), data[7+keyLen:]...)
+	return nil
+}
+
+// ClientSessionCache 按服务端地址和租户保存会话，进程重启后免去ECDHE握手
+type ClientSessionCache interface {
+	Put(key string, session *ClientSession) error
+	Get(key string) (*ClientSession, error)
+	Delete(key string)
+}
+
+// MemorySessionCache 进程内会话缓存
+type MemorySessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]*ClientSession
+}
+
+func NewMemorySessionCache() *MemorySessionCache {
+	return &MemorySessionCache{sessions: make(map[string]*ClientSession)}
+}
+
+func (m *MemorySessionCache) Put(key string, session *ClientSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[key] = session
+	return nil
+}
+
+func (m *MemorySessionCache) Get(key string) (*ClientSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[key]; ok {
+		return session, nil
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (m *MemorySessionCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, key)
+}
+
+// FileSessionCache 每个会话保存为Dir下的一个文件，文件名为key的sha256
+// Secret不为nil时使用secretbox加密保存，密钥由Secret和key派生，文件被改名或复制到其他key下无法解密
+type FileSessionCache struct {
+	Dir    string
+	Secret *[32]byte
+}
+
+func (f *FileSessionCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.Dir, hex.EncodeToString(sum[:]))
+}
+
+// boxKey secretbox密钥 = hmac-sha256(Secret, key)，会话绑定到key
+func (f *FileSessionCache) boxKey(key string) *[32]byte {
+	mac := hmac.New(sha256.New, f.Secret[:])
+	mac.Write([]byte(key))
+	return (*[32]byte)(mac.Sum(nil))
+}
+
+// Put 先写临时文件再rename，避免读到写了一半的会话
+func (f *FileSessionCache) Put(key string, session *ClientSession) error {
+	data, err := session.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if f.Secret != nil {
+		nonce, err := util.Random(24)
+		if err != nil {
+			return err
+		}
+		data = secretbox.Seal(nonce, data, (*[24]byte)(nonce), f.boxKey(key))
+	}
+	if err = os.MkdirAll(f.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+func (f *FileSessionCache) Get(key string) (*ClientSession, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.Secret != nil {
+		if len(data) < 24 {
+			return nil, ErrSessionCorrupted
+		}
+		var ok bool
+		if data, ok = secretbox.Open(nil, data[24:], (*[24]byte)(data[:24]), f.boxKey(key)); !ok {
+			return nil, ErrSessionCorrupted
+		}
+	}
+	session := &ClientSession{}
+	if err = session.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (f *FileSessionCache) Delete(key string) {
+	os.Remove(f.path(key))
+}
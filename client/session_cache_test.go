@@ -0,0 +1,52 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/server"
+	"github.com/ryanx-sir/simple-als/ticket"
+)
+
+func Test_FileSessionCache(t *testing.T) {
+	srv, err := server.NewServer(&server.Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countTransport{Transport: &MemoryTransport{Server: srv}}
+	cache := &FileSessionCache{Dir: t.TempDir(), Secret: &[32]byte{1}}
+	// 模拟进程重启，第二个客户端从缓存恢复会话
+	for i := 0; i < 2; i++ {
+		c, err := NewClient("http://127.0.0.1/wdals", &Config{Transport: transport, SessionCache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if transport.handshakes != 1 {
+		t.Fatal("want 1 handshake", transport.handshakes)
+	}
+
+	other := &FileSessionCache{Dir: cache.Dir, Secret: &[32]byte{2}}
+	if _, err = other.Get("http://127.0.0.1/wdals|"); !errors.Is(err, ErrSessionCorrupted) {
+		t.Fatal("want corrupted", err)
+	}
+
+	// 会话文件复制到其他key下无法解密
+	data, err := os.ReadFile(cache.path("http://127.0.0.1/wdals|"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(cache.path("http://127.0.0.2/wdals|"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Get("http://127.0.0.2/wdals|"); !errors.Is(err, ErrSessionCorrupted) {
+		t.Fatal("want corrupted for copied session", err)
+	}
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// streamBody 解密后的响应，读到服务端close_notify返回io.EOF，被截断时返回io.ErrUnexpectedEOF
+type streamBody struct {
+	io.Reader
+	io.Closer
+}
+
+// exchangeStream Transport未实现StreamTransport时整体收发
+func (c *baseClient) exchangeStream(body io.Reader) (io.ReadCloser, error) {
+	if t, ok := c.transport.(StreamTransport); ok {
+		return t.ExchangeStream(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(context.Background(), data, false)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(resp)), nil
+}
+
+// requestStream 0-RTT PSK流式请求，按需握手
+// 请求体已发出无法重放，票据被拒绝时只清除票据，下次请求重新握手
+func (c *baseClient) requestStream(fullHandshake func(context.Context) error, body io.Reader) (_ io.ReadCloser, err error) {
+	c.loadSession()
+	if c.NeedHandshake() {
+		if err = c.singleFlight(context.Background(), c.session().gen, fullHandshake); err != nil {
+			return nil, err
+		}
+	}
+	suite := util.ResumptionSuite(c.suite)
+	start := c.clock.Now()
+	defer func() {
+		c.observe(util.HandshakeEvent{CipherSuite: suite, ServerName: c.serverName, Resumed: true,
+			Duration: c.clock.Now().Sub(start), Err: err})
+	}()
+	st := c.session()
+	if st.ticket == nil {
+		return nil, ErrNoSession
+	}
+	version := record.SuiteVersion(suite)
+	keySize := record.KeySize(version)
+	hasher := sha256.New()
+
+	clientHello, err := handshake.NewMsg(c.rand, uint32(c.clock.Now().Unix()), st.ticket, suite)
+	if err != nil {
+		return nil, err
+	}
+	if c.serverName != "" {
+		clientHello.SetExtension(handshake.ExtServerName, []byte(c.serverName))
+	}
+	share, err := c.pskKeyShare(clientHello, suite)
+	if err != nil {
+		return nil, err
+	}
+	clientHello.SetExtension(handshake.ExtStream, []byte{1})
+	record1 := record.New(record.TypeHandshake, version, clientHello.Marshal(handshake.TypClientHello))
+	hasher.Write(record1.GetData())
+
+	//earlyKey = kdf(ticketKey+clientNonce)
+	earlyKey := pbkdf2.Key(st.ticketKey, append([]byte(util.EarlyKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogEarly, clientHello.Nonce(), earlyKey)
+
+	// todo 1. 请求体分块加密，以close_notify结束
+	pr, pw := io.Pipe()
+	go func() {
+		w := record.NewWriter(pw, version, earlyKey, 1)
+		w.Header = record1.Marshal()
+		_, err := io.Copy(w, body)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := c.exchangeStream(pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	// todo 2. readServerHello
+	record3, err := record.ReadNew(resp)
+	if err == nil {
+		err = serverAlert(record3)
+	}
+	if err == nil && record3.Type() != record.TypeHandshake {
+		err = util.ErrDataCorrupted
+	}
+	if err != nil {
+		resp.Close()
+		if err == ErrTicketRejected {
+			c.dropSession(st.gen)
+		}
+		return nil, err
+	}
+	hasher.Write(record3.GetData())
+	transcript := hasher.Sum(nil)
+	secret, err := pskSecret(share, st.ticketKey, record3.GetData())
+	if err != nil {
+		resp.Close()
+		return nil, err
+	}
+
+	// todo 3. 响应边读边解密，服务端可能在末尾下发新票据
+	masterKey := pbkdf2.Key(secret, append([]byte(util.MasterKdf),
+		transcript...), 1, keySize, sha256.New)
+	util.WriteKeyLog(c.keyLogWriter, util.KeyLogMaster, clientHello.Nonce(), masterKey)
+	reader := record.NewReader(resp, masterKey, 1)
+	reader.OnHandshake = func(ticketData []byte) error {
+		if len(ticketData) < 5 || ticketData[0] != handshake.TypNewSessionTicket {
+			return util.ErrDataCorrupted
+		}
+		ticketKey := pbkdf2.Key(secret, append([]byte(util.TicketKdf),
+			transcript...), 1, 32, sha256.New)
+		util.WriteKeyLog(c.keyLogWriter, util.KeyLogTicket, clientHello.Nonce(), ticketKey)
+		return c.setTicket(ticketKey, ticketData[1:])
+	}
+	return &streamBody{Reader: reader, Closer: resp}, nil
+}
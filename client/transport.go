@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+)
+
+var ErrResponseTooLarge = errors.New("response too large")
+
+// Transport 发送一次协议数据并返回服务端响应
+// handshake为true时data为ECDHE ClientHello，否则为PSK请求
+type Transport interface {
+	Exchange(data []byte, handshake bool) ([]byte, error)
+}
+
+// ContextTransport 支持取消的Transport，RequestContext的ctx传入每次收发
+type ContextTransport interface {
+	ExchangeContext(ctx context.Context, data []byte, handshake bool) ([]byte, error)
+}
+
+// HTTPTransport ClientHello放在GET参数hello中，PSK请求作为POST请求体
+type HTTPTransport struct {
+	URL    string       // endpoint地址
+	Client *http.Client // 默认30秒超时的http.Client，代理等在此配置
+	Header http.Header  // 附加的请求头
+	// MaxResponseSize 服务端响应上限，默认4MB
+	MaxResponseSize int
+}
+
+func (t *HTTPTransport) Exchange(data []byte, handshake bool) ([]byte, error) {
+	return t.ExchangeContext(context.Background(), data, handshake)
+}
+
+// ExchangeContext ctx取消时中止http请求
+func (t *HTTPTransport) ExchangeContext(ctx context.Context, data []byte, handshake bool) ([]byte, error) {
+	var req *http.Request
+	var err error
+	if handshake {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, t.URL+"?hello="+base64.RawURLEncoding.EncodeToString(data), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range t.Header {
+		req.Header[key] = values
+	}
+	if !handshake {
+		req.Header.Set("Content-Type", "application/x-wdals")
+	}
+	client := t.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.readResponse(resp)
+}
+
+func (t *HTTPTransport) readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	maxResponse := t.MaxResponseSize
+	if maxResponse == 0 {
+		maxResponse = DefaultMaxResponseSize
+	}
+	recv_data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxResponse)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(recv_data) > maxResponse {
+		return nil, ErrResponseTooLarge
+	}
+	return recv_data, nil
+}
+
+// Handler 服务端，与wdals.Server一致
+type Handler interface {
+	Handle(io.Reader) ([]byte, error)
+}
+
+// MemoryTransport 直接调用进程内的服务端，用于测试
+type MemoryTransport struct {
+	Server Handler
+}
+
+func (t *MemoryTransport) Exchange(data []byte, _ bool) ([]byte, error) {
+	return t.Server.Handle(bytes.NewReader(data))
+}
+
+// ExchangeContext 服务端同步处理，只在调用前检查ctx
+func (t *MemoryTransport) ExchangeContext(ctx context.Context, data []byte, handshake bool) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.Exchange(data, handshake)
+}
+
+// StreamTransport 流式收发PSK请求，Transport未实现时RequestStream缓存请求和响应
+type StreamTransport interface {
+	ExchangeStream(body io.Reader) (io.ReadCloser, error)
+}
+
+// ExchangeStream POST请求体边读边发，响应不受MaxResponseSize限制
+func (t *HTTPTransport) ExchangeStream(body io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodPost, t.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range t.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-wdals")
+	client := t.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ExchangeStream 服务端实现HandleStream时经管道边处理边返回
+func (t *MemoryTransport) ExchangeStream(body io.Reader) (io.ReadCloser, error) {
+	srv, ok := t.Server.(interface {
+		HandleStream(r io.Reader, w io.Writer) error
+	})
+	if !ok {
+		resp, err := t.Server.Handle(body)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(resp)), nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(srv.HandleStream(body, pw))
+	}()
+	return pr, nil
+}
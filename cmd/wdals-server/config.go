@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	wdals "github.com/ryanx-sir/simple-als"
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+const (
+	modeEcho  = "echo"  // PSK early data原样返回
+	modeProxy = "proxy" // early data为序列化的HTTP请求，转发到上游后返回序列化的响应
+)
+
+// suiteNames 配置文件中的密码套件名
+var suiteNames = map[string]uint8{
+	"DHE_SECP256R1_WITH_AES_GCM":        wdals.DHE_SECP256R1_WITH_AES_GCM,
+	"DHE_X25519_WITH_XSALSA20_POLY1305": wdals.DHE_X25519_WITH_XSALSA20_POLY1305,
+	"PSK_WITH_AES_GCM":                  wdals.PSK_WITH_AES_GCM,
+	"PSK_WITH_XSALSA20_POLY1305":        wdals.PSK_WITH_XSALSA20_POLY1305,
+}
+
+// config JSON配置文件，零值字段使用默认值
+type config struct {
+	Listen string `json:"listen"` // 默认127.0.0.1:20000
+	Path   string `json:"path"`   // 默认/wdals
+	// CipherSuites 允许的密码套件，ECDHE套件同时允许其PSK恢复套件，为空时不限制
+	CipherSuites []string `json:"cipher_suites"`
+	// Keyring ticket.ParseKeyring格式的票据密钥文件，修改后按KeyringRefresh重新加载
+	// 为空时进程内随机生成，重启后已签发的票据失效
+	Keyring        string   `json:"keyring"`
+	KeyringRefresh duration `json:"keyring_refresh"` // 默认1m
+	TicketLifetime duration `json:"ticket_lifetime"` // 默认24h
+	// SingleUseTicket 票据只能使用一次，proxy模式下开启后才转发POST等非幂等请求
+	SingleUseTicket bool `json:"single_use_ticket"`
+	// IdentityKey PKCS#8 PEM格式的ed25519私钥文件，设置后对ServerHello签名
+	IdentityKey string `json:"identity_key"`
+	Mode        string `json:"mode"`     // echo(默认)或proxy
+	Upstream    string `json:"upstream"` // proxy模式的上游地址，如http://127.0.0.1:8080
+}
+
+// duration 配置中的时长，格式同time.ParseDuration，如"12h"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig path为空时使用默认配置
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = "127.0.0.1:20000"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/wdals"
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = modeEcho
+	}
+	if cfg.KeyringRefresh == 0 {
+		cfg.KeyringRefresh = duration(time.Minute)
+	}
+	if cfg.TicketLifetime == 0 {
+		cfg.TicketLifetime = duration(24 * time.Hour)
+	}
+	switch cfg.Mode {
+	case modeEcho:
+	case modeProxy:
+		if cfg.Upstream == "" {
+			return nil, errors.New("config: upstream required in proxy mode")
+		}
+	default:
+		return nil, fmt.Errorf("config: unknown mode %q", cfg.Mode)
+	}
+	return cfg, nil
+}
+
+// cipherSuites 解析套件名，ECDHE套件签发的票据需要对应的PSK套件恢复
+func (c *config) cipherSuites() ([]uint8, error) {
+	var suites []uint8
+	for _, name := range c.CipherSuites {
+		suite, ok := suiteNames[name]
+		if !ok {
+			return nil, fmt.Errorf("config: unknown cipher suite %q", name)
+		}
+		suites = append(suites, suite)
+		if resumption := util.ResumptionSuite(suite); resumption != suite {
+			suites = append(suites, resumption)
+		}
+	}
+	return suites, nil
+}
+
+// identityKey 读取PKCS#8 PEM格式的ed25519私钥，未配置时返回nil
+func (c *config) identityKey() (ed25519.PrivateKey, error) {
+	if c.IdentityKey == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.IdentityKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("identity key %s: no PEM block", c.IdentityKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("identity key %s: %w", c.IdentityKey, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity key %s: not an ed25519 key", c.IdentityKey)
+	}
+	return privateKey, nil
+}
+
+// ticketEncoder 配置了密钥文件时定期同步，返回的stop用于停止同步
+func (c *config) ticketEncoder(logger *log.Logger) (*ticket.Encoder, func(), error) {
+	lifetime := time.Duration(c.TicketLifetime)
+	if c.Keyring == "" {
+		var key ticket.SecretKey
+		if _, err := rand.Read(key[:]); err != nil {
+			return nil, nil, err
+		}
+		logger.Printf("wdals-server: no keyring configured, tickets are invalidated on restart")
+		return ticket.NewEncoder(lifetime, map[uint16]ticket.SecretKey{1: key}), func() {}, nil
+	}
+	provider := &ticket.FileKeyProvider{Path: c.Keyring}
+	encoder, err := ticket.NewEncoderFromProvider(lifetime, provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := encoder.Watch(provider, time.Duration(c.KeyringRefresh), func(err error) {
+		logger.Printf("wdals-server: reload keyring: %v", err)
+	})
+	return encoder, stop, nil
+}
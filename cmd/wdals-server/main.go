@@ -0,0 +1,103 @@
+// wdals-server 参考服务端，通过HTTP提供协议，应用层为回显或反向代理到上游HTTP服务
+//
+//	go run ./cmd/wdals-server -config wdals-server.json
+//
+// 配置项见config和wdals-server.example.json，不指定配置文件时监听127.0.0.1:20000/wdals并回显
+// 可直接运行client_js/demo.js和client包的Test_SimpleClient
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	wdals "github.com/ryanx-sir/simple-als"
+)
+
+func main() {
+	configPath := flag.String("config", "", "JSON config file, defaults are used when empty")
+	flag.Parse()
+
+	logger := log.Default()
+	if err := run(*configPath, logger); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// run 启动服务直到出错，返回前停止票据密钥同步
+func run(configPath string, logger *log.Logger) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	handler, stop, err := newHandler(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, handler)
+	srv := &http.Server{Addr: cfg.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	logger.Printf("wdals-server: %s mode listening on http://%s%s", cfg.Mode, cfg.Listen, cfg.Path)
+	return srv.ListenAndServe()
+}
+
+// newHandler 按配置创建协议handler，返回的stop用于停止票据密钥同步
+func newHandler(cfg *config, logger *log.Logger) (_ http.Handler, _ func(), err error) {
+	suites, err := cfg.cipherSuites()
+	if err != nil {
+		return nil, nil, err
+	}
+	identityKey, err := cfg.identityKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if identityKey != nil {
+		// 客户端配置ServerKey后校验ServerHello签名
+		logger.Printf("wdals-server: identity public key %s",
+			base64.StdEncoding.EncodeToString(identityKey.Public().(ed25519.PublicKey)))
+	}
+	encoder, stop, err := cfg.ticketEncoder(logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			stop()
+		}
+	}()
+	serverConfig := &wdals.ServerConfig{
+		TicketEncoder:   encoder,
+		TicketLifetime:  time.Duration(cfg.TicketLifetime),
+		SingleUseTicket: cfg.SingleUseTicket,
+		CipherSuites:    suites,
+		IdentityKey:     identityKey,
+		Logger:          logger,
+	}
+
+	if cfg.Mode == modeProxy {
+		var upstream *url.URL
+		if upstream, err = url.Parse(cfg.Upstream); err != nil {
+			return nil, nil, err
+		}
+		var middleware func(http.Handler) http.Handler
+		if middleware, err = wdals.Middleware(serverConfig); err != nil {
+			return nil, nil, err
+		}
+		return middleware(httputil.NewSingleHostReverseProxy(upstream)), stop, nil
+	}
+	serverConfig.Handler = wdals.HandlerFunc(func(req *wdals.Request) ([]byte, error) {
+		return req.Data, nil
+	})
+	var srv wdals.Server
+	if srv, err = wdals.NewServer(serverConfig); err != nil {
+		return nil, nil, err
+	}
+	return wdals.NewHTTPHandler(srv), stop, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wdals "github.com/ryanx-sir/simple-als"
+)
+
+func newTestServer(t *testing.T, cfg *config) *httptest.Server {
+	handler, stop, err := newHandler(cfg, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, handler)
+	hs := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		hs.Close()
+		stop()
+	})
+	return hs
+}
+
+func Test_EchoMode(t *testing.T) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := newTestServer(t, cfg)
+	c, err := wdals.NewClient(hs.URL+"/wdals", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Request([]byte("ping"))
+	if err != nil || string(resp) != "ping" {
+		t.Fatal("unexpected echo", string(resp), err)
+	}
+}
+
+func Test_ProxyMode(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream:" + r.URL.Path))
+	}))
+	defer upstream.Close()
+	hs := newTestServer(t, &config{Path: "/wdals", Mode: modeProxy, Upstream: upstream.URL})
+
+	c, err := wdals.NewClient(hs.URL+"/wdals", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://api.local/status", nil)
+	var buf bytes.Buffer
+	req.Write(&buf)
+	data, err := c.Request(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "upstream:/status" {
+		t.Fatal("unexpected upstream response", string(body))
+	}
+}
+
+func Test_LoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	keyring := filepath.Join(dir, "keyring.json")
+	os.WriteFile(keyring, []byte(`{"active":1,"keys":{"1":"`+
+		base64.StdEncoding.EncodeToString(make([]byte, 32))+`"}}`), 0600)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	identity := filepath.Join(dir, "identity.pem")
+	os.WriteFile(identity, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte(`{
+		"path": "/als",
+		"cipher_suites": ["DHE_X25519_WITH_XSALSA20_POLY1305"],
+		"keyring": "`+keyring+`",
+		"ticket_lifetime": "1h",
+		"identity_key": "`+identity+`"
+	}`), 0600)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:20000" || cfg.Mode != modeEcho {
+		t.Fatal("defaults not applied", cfg.Listen, cfg.Mode)
+	}
+	hs := newTestServer(t, cfg)
+	// 只允许X25519套件及其PSK恢复套件，客户端校验服务端签名
+	c, err := wdals.NewClient(hs.URL+"/als", &wdals.ClientConfig{
+		CipherSuites: []uint8{wdals.DHE_X25519_WITH_XSALSA20_POLY1305},
+		ServerKey:    publicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.Request([]byte("ping")); err != nil || string(resp) != "ping" {
+		t.Fatal("unexpected echo", string(resp), err)
+	}
+	c, _ = wdals.NewClient(hs.URL+"/als", nil)
+	if err = c.Handshake(); err == nil {
+		t.Fatal("expected disallowed cipher suite to fail")
+	}
+
+	os.WriteFile(path, []byte(`{"cipher_suites": ["RSA"]}`), 0600)
+	if cfg, err = loadConfig(path); err == nil {
+		_, err = cfg.cipherSuites()
+	}
+	if err == nil {
+		t.Fatal("expected unknown cipher suite to fail")
+	}
+	os.WriteFile(path, []byte(`{"mode": "proxy"}`), 0600)
+	if _, err = loadConfig(path); err == nil {
+		t.Fatal("expected proxy mode without upstream to fail")
+	}
+}
+
+func Test_RunListenError(t *testing.T) {
+	// 端口被占用时run返回错误，由main退出，不跳过stop
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"listen": "`+l.Addr().String()+`"}`), 0600)
+	if err = run(path, log.New(io.Discard, "", 0)); err == nil {
+		t.Fatal("expected listen error")
+	}
+}
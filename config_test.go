@@ -0,0 +1,29 @@
+package wdals
+
+import "testing"
+
+func Test_ConfigValidate(t *testing.T) {
+	invalidServer := []*ServerConfig{
+		nil,
+		{},
+		{TicketEncoder: newTestEncoder(), CipherSuites: []uint8{0x01}},
+		{TicketEncoder: newTestEncoder(), Retry: &RetryConfig{}},
+		{TicketEncoder: newTestEncoder(), MaxEarlyData: -1},
+	}
+	for i, config := range invalidServer {
+		if _, err := NewServer(config); err == nil {
+			t.Fatal("want server config error", i)
+		}
+	}
+
+	invalidClient := []*ClientConfig{
+		{CipherSuites: []uint8{PSK_WITH_AES_GCM}},
+		{ServerKey: []byte("short")},
+		{MaxResponseSize: -1},
+	}
+	for i, config := range invalidClient {
+		if _, err := NewClient("http://127.0.0.1", config); err == nil {
+			t.Fatal("want client config error", i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"crypto/sha256"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// closeNotifyTimeout Close发送close_notify的最长等待时间
+const closeNotifyTimeout = 5 * time.Second
+
+// Conn 握手或会话恢复完成后的加密长连接，每个方向使用独立的密钥和递增序列号
+// 序列号参与nonce，record被丢弃、重放或乱序时解密失败，之后的读取都返回该错误
+// Read读到对端close_notify返回io.EOF，之前连接断开返回io.ErrUnexpectedEOF
+type Conn struct {
+	net.Conn
+
+	readMu sync.Mutex
+	reader *record.Reader
+
+	writeMu sync.Mutex
+	writer  *record.Writer
+}
+
+// TrafficKeys 由握手密钥和transcript hash派生双向流量密钥，长度为record.KeySize(version)
+func TrafficKeys(version uint8, secret, transcript []byte) (clientKey, serverKey []byte) {
+	keySize := record.KeySize(version)
+	clientKey = pbkdf2.Key(secret, append([]byte(util.ClientTrafficKdf), transcript...), 1, keySize, sha256.New)
+	serverKey = pbkdf2.Key(secret, append([]byte(util.ServerTrafficKdf), transcript...), 1, keySize, sha256.New)
+	return
+}
+
+func New(c net.Conn, version uint8, readKey, writeKey []byte) *Conn {
+	return &Conn{
+		Conn:   c,
+		reader: record.NewReader(c, readKey, 0),
+		writer: record.NewWriter(c, version, writeKey, 0),
+	}
+}
+
+// HandleHandshake 收到加密handshake record时回调，如恢复会话后服务端下发的新票据，需在首次Read前设置
+func (c *Conn) HandleHandshake(fn func(data []byte) error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.reader.OnHandshake = fn
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.reader.Read(b)
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writer.Write(b)
+}
+
+// WriteHandshake 加密发送handshake record
+func (c *Conn) WriteHandshake(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writer.WriteRecord(record.TypeHandshake, data)
+}
+
+// CloseWrite 发送close_notify，之后的写入返回record.ErrWriteClosed，仍可继续读取
+func (c *Conn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writer.Close()
+}
+
+// Close 尽量发送close_notify后关闭底层连接
+func (c *Conn) Close() error {
+	c.Conn.SetWriteDeadline(time.Now().Add(closeNotifyTimeout))
+	c.CloseWrite()
+	return c.Conn.Close()
+}
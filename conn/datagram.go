@@ -0,0 +1,415 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// MaxDatagramSize 单个datagram最大长度
+const MaxDatagramSize = 64 << 10
+
+// RetransmitTimeout 握手flight首次重传等待时间，之后每次加倍
+const RetransmitTimeout = time.Second
+
+// MaxRetransmits 握手flight最多重传次数
+const MaxRetransmits = 4
+
+const (
+	epochHandshake uint16 = 0 // 明文握手flight
+	epochTraffic   uint16 = 1 // 流量密钥加密
+)
+
+// ConnectionIDCount 握手时服务端分配的connection ID个数
+const ConnectionIDCount = 4
+
+// maxSeqGap 切换connection ID或回复地址时发送序列号随机跳过的上限
+const maxSeqGap = 1 << 24
+
+// pathChallengeLen 路径挑战随机数长度
+const pathChallengeLen = 8
+
+var ErrHandshakeTimeout = errors.New("datagram handshake timeout")
+var ErrDatagramTooLarge = errors.New("datagram exceeds max record size")
+
+// ErrNoConnectionID 未协商connection ID或已全部用完
+var ErrNoConnectionID = errors.New("no spare connection id")
+
+// ConnectionIDConn 按connection ID而非来源地址路由datagram的服务端连接，由listener实现
+type ConnectionIDConn interface {
+	net.Conn
+	// NewConnectionIDs 分配n个路由到该连接的connection ID
+	NewConnectionIDs(n int) ([][]byte, error)
+	// PeerMoved 最近一次Read返回的datagram来源与回复地址不同
+	PeerMoved() bool
+	// ChallengePeer 向最近一次Read返回的datagram来源发送路径挑战b，不改变回复地址
+	ChallengePeer(b []byte) error
+	// ConfirmPeer 最近一次Read返回的datagram是路径响应且来源为挑战发往的地址，之后向其回复，地址改变时返回true
+	ConfirmPeer() bool
+}
+
+// FlightConn datagram握手阶段，一个flight作为一个epoch 0 record收发
+// c的每次Read返回一个datagram，无法解析或非epoch 0的datagram被忽略
+type FlightConn struct {
+	c       net.Conn
+	version uint8
+	rand    io.Reader
+	seq     uint32
+	buf     []byte
+	peer    []byte // 对端上一个flight
+	last    []byte // 本端上一个发送的datagram
+}
+
+// NewFlightConn rand用于握手后会话的序列号跳过和路径挑战
+func NewFlightConn(c net.Conn, version uint8, rand io.Reader) *FlightConn {
+	return &FlightConn{c: c, version: version, rand: rand, buf: make([]byte, MaxDatagramSize)}
+}
+
+// Write 发送握手flight，每次发送使用新的序列号
+func (f *FlightConn) Write(flight []byte) error {
+	f.last = record.New(record.TypeHandshake, f.version, flight).MarshalDatagram(epochHandshake, f.seq)
+	f.seq++
+	_, err := f.c.Write(f.last)
+	return err
+}
+
+// Read 读取对端下一个握手flight
+func (f *FlightConn) Read() ([]byte, error) {
+	for {
+		n, err := f.c.Read(f.buf)
+		if err != nil {
+			return nil, err
+		}
+		rec, epoch, _, err := record.ParseDatagram(f.buf[:n])
+		if err != nil || epoch != epochHandshake || rec.Type() != record.TypeHandshake {
+			continue
+		}
+		f.peer = rec.GetData()
+		return f.peer, nil
+	}
+}
+
+// Exchange 发送握手flight并返回对端flight，超时未收到时重传，等待时间每次加倍
+func (f *FlightConn) Exchange(flight []byte) ([]byte, error) {
+	defer f.c.SetReadDeadline(time.Time{})
+	timeout := RetransmitTimeout
+	for i := 0; i <= MaxRetransmits; i++ {
+		if err := f.Write(flight); err != nil {
+			return nil, err
+		}
+		f.c.SetReadDeadline(time.Now().Add(timeout))
+		resp, err := f.Read()
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return resp, err
+		}
+		timeout *= 2
+	}
+	return nil, ErrHandshakeTimeout
+}
+
+// Established 本端发送了最后一个握手flight时使用，之后收到对端重传的上一个flight时重发
+func (f *FlightConn) Established(readKey, writeKey []byte) *Datagram {
+	d := NewDatagram(f.c, f.version, f.rand, readKey, writeKey)
+	d.peerFlight, d.lastFlight = f.peer, f.last
+	return d
+}
+
+// Datagram 基于datagram的加密会话，每次Write发送一个record，每次Read返回一个record的数据
+// record携带显式序列号，允许乱序和丢失，重复、落后于防重放窗口或解密失败的record被丢弃
+// 读到对端close_notify后返回io.EOF，close_notify可能丢失，需结合deadline判断对端离开
+// 服务端收到客户端新地址上的最新record后发送路径挑战，收到该地址上的路径响应后才改为向其回复，
+// 验证完成前仍向原地址回复，期间的响应可能丢失
+type Datagram struct {
+	version uint8
+	rand    io.Reader
+
+	connMu sync.Mutex
+	conn   net.Conn
+
+	readMu      sync.Mutex
+	readKey     []byte
+	window      record.ReplayWindow
+	buf         []byte
+	peerFlight  []byte
+	lastFlight  []byte
+	readEOF     bool
+	challenge   []byte    // 未完成的路径挑战
+	challengeAt time.Time // 上次发送路径挑战的时间，超过RetransmitTimeout未响应时重发
+
+	writeMu     sync.Mutex
+	writeKey    []byte
+	writeSeq    uint32
+	writeClosed bool
+	cid         []byte   // 发送时携带的connection ID
+	spareCIDs   [][]byte // 未使用的connection ID
+}
+
+func NewDatagram(c net.Conn, version uint8, rand io.Reader, readKey, writeKey []byte) *Datagram {
+	return &Datagram{
+		conn:     c,
+		version:  version,
+		rand:     rand,
+		readKey:  readKey,
+		writeKey: writeKey,
+		buf:      make([]byte, MaxDatagramSize),
+	}
+}
+
+func (d *Datagram) transport() net.Conn {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	return d.conn
+}
+
+// SetConnectionIDs 设置服务端分配的connection ID列表，使用第一个，其余供RotateConnectionID使用
+func (d *Datagram) SetConnectionIDs(ids []byte) error {
+	if len(ids)%record.ConnectionIDLen != 0 {
+		return util.ErrDataCorrupted
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	d.cid, d.spareCIDs = nil, nil
+	for len(ids) > 0 {
+		d.spareCIDs = append(d.spareCIDs, ids[:record.ConnectionIDLen])
+		ids = ids[record.ConnectionIDLen:]
+	}
+	if len(d.spareCIDs) == 0 {
+		return nil
+	}
+	return d.rotate()
+}
+
+// ConnectionID 当前发送时携带的connection ID，未协商时为nil
+func (d *Datagram) ConnectionID() []byte {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	return d.cid
+}
+
+// RotateConnectionID 切换到下一个未使用的connection ID，发送序列号随机跳过一段，
+// 旁路观察者无法按ID或连续的序列号关联前后的数据，收发节奏和长度仍可能被用于关联
+func (d *Datagram) RotateConnectionID() error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	return d.rotate()
+}
+
+func (d *Datagram) rotate() error {
+	if len(d.spareCIDs) == 0 {
+		return ErrNoConnectionID
+	}
+	if err := d.skipSeq(); err != nil {
+		return err
+	}
+	d.cid, d.spareCIDs = d.spareCIDs[0], d.spareCIDs[1:]
+	return nil
+}
+
+// skipSeq 发送序列号随机跳过[1, maxSeqGap]，对端防重放窗口只要求序列号递增
+func (d *Datagram) skipSeq() error {
+	b, err := util.RandomFrom(d.rand, 4)
+	if err != nil {
+		return err
+	}
+	gap := binary.BigEndian.Uint32(b)%maxSeqGap + 1
+	if d.writeSeq > math.MaxUint32-gap {
+		return record.ErrSequenceExhausted
+	}
+	d.writeSeq += gap
+	return nil
+}
+
+// Migrate 切换到新的底层连接并轮换connection ID，如移动网络切换，旧连接被关闭
+// 服务端收到新地址上通过认证的record后发送路径挑战，Read处理挑战后服务端才向新地址回复，deadline需重新设置
+func (d *Datagram) Migrate(c net.Conn) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if err := d.rotate(); err != nil {
+		return err
+	}
+	d.connMu.Lock()
+	old := d.conn
+	d.conn = c
+	d.connMu.Unlock()
+	return old.Close()
+}
+
+// Read b小于record数据时多余部分被丢弃
+func (d *Datagram) Read(b []byte) (int, error) {
+	d.readMu.Lock()
+	defer d.readMu.Unlock()
+	for !d.readEOF {
+		c := d.transport()
+		n, err := c.Read(d.buf)
+		if err != nil {
+			if c != d.transport() { // Migrate关闭了旧连接
+				continue
+			}
+			return 0, err
+		}
+		rec, epoch, seq, err := record.ParseDatagram(d.buf[:n])
+		if err != nil {
+			continue
+		}
+		if epoch == epochHandshake {
+			// 对端未收到本端最后一个握手flight，重发
+			if d.lastFlight != nil && bytes.Equal(rec.GetData(), d.peerFlight) {
+				c.Write(d.lastFlight)
+			}
+			continue
+		}
+		if epoch != epochTraffic || !d.window.Check(seq) {
+			continue
+		}
+		if err = rec.Open(d.readKey, seq); err != nil {
+			continue
+		}
+		latest := d.window.Accept(seq)
+		if cc, ok := c.(ConnectionIDConn); ok && latest && rec.Type() != record.TypeHandshake && cc.PeerMoved() {
+			d.challengePeer(cc)
+		}
+		switch rec.Type() {
+		case record.TypeApplicationData:
+			return copy(b, rec.GetData()), nil
+		case record.TypeHandshake:
+			d.pathMessage(c, rec.GetData())
+		case record.TypeAlert:
+			if alert, _ := rec.Alert(); alert == record.AlertCloseNotify {
+				d.readEOF = true
+				break
+			}
+			return 0, record.ErrAlert
+		}
+	}
+	return 0, io.EOF
+}
+
+// challengePeer 通过认证的最新record来自新地址时，向该地址发送路径挑战，伪造或重放的datagram无法劫持会话
+// 未响应的挑战超过RetransmitTimeout才重发，挑战数据不变，客户端持续发送时仍能完成验证
+func (d *Datagram) challengePeer(cc ConnectionIDConn) {
+	if d.challenge != nil && time.Since(d.challengeAt) < RetransmitTimeout {
+		return
+	}
+	if d.challenge == nil {
+		challenge, err := util.RandomFrom(d.rand, pathChallengeLen)
+		if err != nil {
+			return
+		}
+		d.challenge = challenge
+	}
+	d.writeMu.Lock()
+	out, err := d.sealRecord(record.TypeHandshake, append([]byte{handshake.TypPathChallenge}, d.challenge...))
+	d.writeMu.Unlock()
+	if err != nil {
+		return
+	}
+	d.challengeAt = time.Now()
+	cc.ChallengePeer(out)
+}
+
+// pathMessage 客户端原样返回路径挑战；服务端收到挑战发往地址上的路径响应后改为向其回复，
+// 回复地址改变时发送序列号随机跳过，新旧地址上的数据无法按序列号衔接
+func (d *Datagram) pathMessage(c net.Conn, msg []byte) {
+	if len(msg) != 1+pathChallengeLen {
+		return
+	}
+	switch msg[0] {
+	case handshake.TypPathChallenge:
+		d.writeMu.Lock()
+		d.writeRecord(record.TypeHandshake, append([]byte{handshake.TypPathResponse}, msg[1:]...))
+		d.writeMu.Unlock()
+	case handshake.TypPathResponse:
+		cc, ok := c.(ConnectionIDConn)
+		if !ok || d.challenge == nil || !hmac.Equal(msg[1:], d.challenge) || !cc.ConfirmPeer() {
+			return
+		}
+		d.challenge, d.challengeAt = nil, time.Time{}
+		d.writeMu.Lock()
+		d.skipSeq()
+		d.writeMu.Unlock()
+	}
+}
+
+// Write b作为一个record发送，超过record.MaxPlaintext返回ErrDatagramTooLarge
+func (d *Datagram) Write(b []byte) (int, error) {
+	if len(b) > record.MaxPlaintext {
+		return 0, ErrDatagramTooLarge
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if err := d.writeRecord(record.TypeApplicationData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (d *Datagram) writeRecord(typ uint8, data []byte) error {
+	out, err := d.sealRecord(typ, data)
+	if err != nil {
+		return err
+	}
+	_, err = d.transport().Write(out)
+	return err
+}
+
+// sealRecord 加密为携带下一个序列号的datagram，调用方持有writeMu
+func (d *Datagram) sealRecord(typ uint8, data []byte) ([]byte, error) {
+	if d.writeClosed {
+		return nil, record.ErrWriteClosed
+	}
+	if d.writeSeq == math.MaxUint32 {
+		return nil, record.ErrSequenceExhausted
+	}
+	rec := record.New(typ, d.version, append([]byte(nil), data...))
+	if err := rec.Seal(d.writeKey, d.writeSeq); err != nil {
+		return nil, err
+	}
+	out := rec.MarshalDatagram(epochTraffic, d.writeSeq)
+	if d.cid != nil {
+		out = record.WithConnectionID(d.cid, out)
+	}
+	d.writeSeq++
+	return out, nil
+}
+
+// Close 发送close_notify后关闭底层连接
+func (d *Datagram) Close() error {
+	d.writeMu.Lock()
+	if !d.writeClosed {
+		d.writeRecord(record.TypeAlert, []byte{record.AlertCloseNotify})
+		d.writeClosed = true
+	}
+	d.writeMu.Unlock()
+	return d.transport().Close()
+}
+
+func (d *Datagram) LocalAddr() net.Addr {
+	return d.transport().LocalAddr()
+}
+
+func (d *Datagram) RemoteAddr() net.Addr {
+	return d.transport().RemoteAddr()
+}
+
+func (d *Datagram) SetDeadline(t time.Time) error {
+	return d.transport().SetDeadline(t)
+}
+
+func (d *Datagram) SetReadDeadline(t time.Time) error {
+	return d.transport().SetReadDeadline(t)
+}
+
+func (d *Datagram) SetWriteDeadline(t time.Time) error {
+	return d.transport().SetWriteDeadline(t)
+}
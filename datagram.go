@@ -0,0 +1,385 @@
+package wdals
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/client"
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/server"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// peerQueueSize 每个对端待读取的datagram上限，读取不及时时丢弃
+const peerQueueSize = 64
+
+// maxPendingPeers 握手中的对端上限，超过时丢弃新对端的握手datagram
+const maxPendingPeers = 1024
+
+type datagramServer interface {
+	HandshakeDatagram(net.Conn) (*conn.Datagram, error)
+	DatagramRetry(peer *server.Peer, datagram []byte) ([]byte, error)
+}
+
+// datagramListener 按connection ID或对端地址分发datagram，Accept返回已完成服务端握手的会话
+type datagramListener struct {
+	pc     net.PacketConn
+	server datagramServer
+	rand   io.Reader // 分配connection ID，默认crypto/rand
+
+	mu    sync.Mutex
+	peers map[string]*peerConn // 按地址，用于握手和未协商connection ID的会话
+	cids  map[string]*peerConn // 按connection ID，客户端切换地址后仍能路由
+
+	pending int // 握手中的对端数
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// ListenDatagram 监听UDP并返回加密listener，每个对端对应一个会话
+// 客户端支持时协商connection ID，客户端地址变化后会话不中断
+func ListenDatagram(network, addr string, config *ServerConfig) (net.Listener, error) {
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewDatagramListener(pc, config)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewDatagramListener 包装已有PacketConn，握手在后台完成
+func NewDatagramListener(pc net.PacketConn, config *ServerConfig) (net.Listener, error) {
+	srv, err := server.NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	l := &datagramListener{
+		pc:     pc,
+		server: srv,
+		rand:   config.Rand,
+		peers:  make(map[string]*peerConn),
+		cids:   make(map[string]*peerConn),
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	if l.rand == nil {
+		l.rand = rand.Reader
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *datagramListener) serve() {
+	buf := make([]byte, conn.MaxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			l.Close()
+			return
+		}
+		cid, packet := record.SplitConnectionID(append([]byte(nil), buf[:n]...))
+		l.mu.Lock()
+		var p *peerConn
+		if cid != nil {
+			p = l.cids[string(cid)]
+		} else {
+			p = l.peers[addr.String()]
+		}
+		l.mu.Unlock()
+		if p == nil && cid == nil {
+			p = l.newPeer(addr, packet)
+		}
+		if p == nil {
+			continue
+		}
+		select {
+		case p.packets <- datagram{data: packet, from: addr}:
+		default:
+		}
+	}
+}
+
+// newPeer 新对端的握手datagram先无状态回复cookie，携带有效cookie时才建立会话
+// 伪造来源地址收不到cookie，不会占用会话状态或触发ECDHE
+func (l *datagramListener) newPeer(addr net.Addr, packet []byte) *peerConn {
+	retry, err := l.server.DatagramRetry(&server.Peer{RemoteAddr: addr.String()}, packet)
+	if err != nil {
+		return nil
+	}
+	if retry != nil {
+		l.pc.WriteTo(retry, addr)
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p := l.peers[addr.String()]; p != nil {
+		return p
+	}
+	if l.pending >= maxPendingPeers {
+		return nil
+	}
+	l.pending++
+	p := &peerConn{listener: l, addr: addr, packets: make(chan datagram, peerQueueSize), closed: make(chan struct{})}
+	l.peers[addr.String()] = p
+	go l.handshake(p)
+	return p
+}
+
+func (l *datagramListener) handshake(p *peerConn) {
+	p.SetDeadline(time.Now().Add(HandshakeTimeout))
+	d, err := l.server.HandshakeDatagram(p)
+	l.mu.Lock()
+	l.pending--
+	l.mu.Unlock()
+	if err != nil {
+		p.Close()
+		return
+	}
+	p.SetDeadline(time.Time{})
+	select {
+	case l.conns <- d:
+	case <-l.done:
+		d.Close()
+	}
+}
+
+func (l *datagramListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听，已建立的会话不再收到数据
+func (l *datagramListener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.pc.Close()
+	})
+	return
+}
+
+func (l *datagramListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// newConnectionID 随机分配未被占用的connection ID
+func (l *datagramListener) newConnectionID(p *peerConn) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		cid, err := util.RandomFrom(l.rand, record.ConnectionIDLen)
+		if err != nil {
+			return nil, err
+		}
+		if l.cids[string(cid)] == nil {
+			l.cids[string(cid)] = p
+			return cid, nil
+		}
+	}
+}
+
+// datagram from为来源地址，datagram通过认证前不改变回复地址
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// peerConn 单个对端的datagram连接，每次Read返回一个datagram，实现conn.ConnectionIDConn
+type peerConn struct {
+	listener *datagramListener
+	packets  chan datagram
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu           sync.Mutex
+	addr         net.Addr // 回复地址
+	from         net.Addr // 最近一次Read返回的datagram来源
+	challenged   net.Addr // 路径挑战发往的地址
+	cids         [][]byte
+	readDeadline time.Time
+}
+
+func (p *peerConn) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	deadline := p.readDeadline
+	p.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case packet := <-p.packets:
+		p.mu.Lock()
+		p.from = packet.from
+		p.mu.Unlock()
+		return copy(b, packet.data), nil
+	case <-p.closed:
+		return 0, net.ErrClosed
+	case <-p.listener.done:
+		return 0, net.ErrClosed
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *peerConn) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return p.listener.pc.WriteTo(b, p.RemoteAddr())
+}
+
+// NewConnectionIDs 分配路由到该对端的connection ID，对端关闭时一并释放
+func (p *peerConn) NewConnectionIDs(n int) ([][]byte, error) {
+	cids := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		cid, err := p.listener.newConnectionID(p)
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, cid)
+	}
+	p.mu.Lock()
+	p.cids = append(p.cids, cids...)
+	p.mu.Unlock()
+	return cids, nil
+}
+
+// PeerMoved 最近一次Read返回的datagram来自其他地址
+func (p *peerConn) PeerMoved() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.from != nil && p.from.String() != p.addr.String()
+}
+
+// ChallengePeer 向最近一次Read返回的datagram来源发送路径挑战，回复地址不变
+func (p *peerConn) ChallengePeer(b []byte) error {
+	p.mu.Lock()
+	from := p.from
+	p.challenged = from
+	p.mu.Unlock()
+	if from == nil {
+		return nil
+	}
+	_, err := p.listener.pc.WriteTo(b, from)
+	return err
+}
+
+// ConfirmPeer 挑战发往的地址返回了路径响应，客户端切换地址后向其新地址回复
+func (p *peerConn) ConfirmPeer() bool {
+	l := p.listener
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.from == nil || p.challenged == nil || p.from.String() != p.challenged.String() {
+		return false
+	}
+	p.challenged = nil
+	if p.from.String() == p.addr.String() {
+		return false
+	}
+	if l.peers[p.addr.String()] == p {
+		delete(l.peers, p.addr.String())
+	}
+	if l.peers[p.from.String()] == nil {
+		l.peers[p.from.String()] = p
+	}
+	p.addr = p.from
+	return true
+}
+
+// Close 移除对端及其connection ID，之后该地址的握手datagram建立新会话
+func (p *peerConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		l := p.listener
+		l.mu.Lock()
+		p.mu.Lock()
+		if l.peers[p.addr.String()] == p {
+			delete(l.peers, p.addr.String())
+		}
+		for _, cid := range p.cids {
+			delete(l.cids, string(cid))
+		}
+		p.mu.Unlock()
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (p *peerConn) LocalAddr() net.Addr {
+	return p.listener.pc.LocalAddr()
+}
+
+func (p *peerConn) RemoteAddr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr
+}
+
+func (p *peerConn) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *peerConn) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline datagram写入不阻塞
+func (p *peerConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// DialDatagram 建立UDP会话并完成客户端握手，握手flight超时重传
+// 与Dial相同，config.CipherSuites非空时须包含DHE_SECP256R1_WITH_AES_GCM，否则返回ErrConnCipherSuite
+func DialDatagram(network, addr string, config *ClientConfig) (net.Conn, error) {
+	if err := connSuite(config); err != nil {
+		return nil, err
+	}
+	alClient, err := client.NewAesGcmClient(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := alClient.HandshakeDatagram(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,503 @@
+package wdals
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/client"
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+)
+
+// lossyProxy 转发UDP datagram，丢弃服务端的前两个datagram，客户端的datagram发送两次
+func lossyProxy(t *testing.T, serverAddr string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := net.Dial("udp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		up.Close()
+	})
+	var mu sync.Mutex
+	var clientAddr net.Addr
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			clientAddr = addr
+			mu.Unlock()
+			up.Write(buf[:n])
+			up.Write(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64<<10)
+		for dropped := 0; ; dropped++ {
+			n, err := up.Read(buf)
+			if err != nil {
+				return
+			}
+			if dropped < 2 {
+				continue
+			}
+			mu.Lock()
+			addr := clientAddr
+			mu.Unlock()
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func Test_Datagram(t *testing.T) {
+	l, err := ListenDatagram("udp", "127.0.0.1:0", &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				closed <- err
+				return
+			}
+			c.Write(append([]byte("echo:"), buf[:n]...))
+		}
+	}()
+
+	// 服务端对重复ClientHello的响应都被丢弃，客户端超时重传ClientHello，服务端重发响应
+	c, err := DialDatagram("udp", lossyProxy(t, l.Addr().String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf("metric-%d", i)
+		if _, err = c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if string(buf[:n]) != "echo:"+msg {
+			t.Fatal("unexpected echo", string(buf[:n]))
+		}
+	}
+	// 每个datagram都被重复发送，重放的record被丢弃，不会有多余的响应
+	c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err = c.Read(buf); !os.IsTimeout(err) {
+		t.Fatal("expected no replayed echo", err)
+	}
+	c.Close()
+	select {
+	case err = <-closed:
+		if err != io.EOF {
+			t.Fatal("expected close_notify", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("close_notify not received")
+	}
+}
+
+func Test_DatagramMigrate(t *testing.T) {
+	l, err := ListenDatagram("udp", "127.0.0.1:0", &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	peers := make(chan string, 16)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			peers <- c.RemoteAddr().String()
+			c.Write(append([]byte("echo:"), buf[:n]...))
+		}
+	}()
+
+	c, err := DialDatagram("udp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	d := c.(*conn.Datagram)
+	buf := make([]byte, 64<<10)
+	exchange := func(msg string) string {
+		t.Helper()
+		if _, err := d.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		d.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := d.Read(buf)
+		if err != nil {
+			t.Fatal(msg, err)
+		}
+		if string(buf[:n]) != "echo:"+msg {
+			t.Fatal("unexpected echo", string(buf[:n]))
+		}
+		return <-peers
+	}
+	first := d.ConnectionID()
+	if first == nil {
+		t.Fatal("connection id not negotiated")
+	}
+	before := exchange("before")
+
+	// 伪造来源：携带有效connection ID但无法通过认证的datagram不会改变回复地址
+	spoof, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoof.Close()
+	spoof.Write(record.WithConnectionID(first, record.New(record.TypeApplicationData, record.ProtocolAesGcm,
+		make([]byte, 32)).MarshalDatagram(1, 1000)))
+	if exchange("spoofed") != before {
+		t.Fatal("spoofed datagram changed peer address")
+	}
+
+	// 切换到新的本地地址，connection ID随之轮换，会话不中断
+	moved, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Migrate(moved); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(d.ConnectionID(), first) {
+		t.Fatal("connection id not rotated")
+	}
+	// 新地址上的首个record触发路径挑战，验证完成前服务端仍向原地址回复
+	d.Write([]byte("probe"))
+	d.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err = d.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("reply sent to unvalidated address", err)
+	}
+	if <-peers != before {
+		t.Fatal("peer address changed before path validation")
+	}
+	after := exchange("after")
+	if after == before || after != moved.LocalAddr().String() {
+		t.Fatal("server did not follow migration", before, after)
+	}
+	for i := 1; i < conn.ConnectionIDCount-1; i++ {
+		if err = d.RotateConnectionID(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.RotateConnectionID(); err != conn.ErrNoConnectionID {
+		t.Fatal("expected connection ids to run out", err)
+	}
+	exchange("rotated")
+}
+
+// replayConn 设置replay后每个datagram先由replay从另一个地址发送一次，模拟抢先重放的中间人
+type replayConn struct {
+	net.Conn
+	replay net.Conn
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	if c.replay != nil {
+		c.replay.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+func Test_DatagramPathValidation(t *testing.T) {
+	l, err := ListenDatagram("udp", "127.0.0.1:0", &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	peers := make(chan string, 16)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			peers <- c.RemoteAddr().String()
+			c.Write(buf[:n])
+		}
+	}()
+
+	alClient, err := client.NewAesGcmClient(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &replayConn{Conn: nc}
+	d, err := alClient.HandshakeDatagram(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if rc.replay, err = net.Dial("udp", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer rc.replay.Close()
+
+	// 重放的record先于原datagram到达，重放地址收不到路径挑战之外的数据，回复地址不变
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprint("ping", i)
+		d.Write([]byte(msg))
+		d.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := d.Read(buf); err != nil || string(buf[:n]) != msg {
+			t.Fatal("unexpected echo", string(buf[:n]), err)
+		}
+		if peer := <-peers; peer != nc.LocalAddr().String() {
+			t.Fatal("replayed datagram changed peer address", peer)
+		}
+	}
+}
+
+func Test_DialDatagramCipherSuite(t *testing.T) {
+	_, err := DialDatagram("udp", "127.0.0.1:0", &ClientConfig{CipherSuites: []uint8{DHE_X25519_WITH_XSALSA20_POLY1305}})
+	if !errors.Is(err, ErrConnCipherSuite) {
+		t.Fatal("want ErrConnCipherSuite", err)
+	}
+}
+
+func Test_DatagramCookie(t *testing.T) {
+	ln, err := ListenDatagram("udp", "127.0.0.1:0", &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := ln.(*datagramListener)
+	c, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	privateKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	clientHello, err := handshake.NewMsg(rand.Reader, uint32(time.Now().Unix()), privateKey.PublicKey().Bytes(), DHE_SECP256R1_WITH_AES_GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exchange 发送ClientHello datagram，返回服务端响应的握手消息，超时未响应返回nil
+	exchange := func() []byte {
+		hello := record.NewAesGcm(record.TypeHandshake, clientHello.Marshal(handshake.TypClientHello)).Marshal()
+		c.Write(record.New(record.TypeHandshake, record.ProtocolAesGcm, hello).MarshalDatagram(0, 0))
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 64<<10)
+		n, err := c.Read(buf)
+		if err != nil {
+			return nil
+		}
+		flight, _, _, err := record.ParseDatagram(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := record.ReadNew(bytes.NewReader(flight.GetData()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetData()
+	}
+	peers := func() int {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.peers)
+	}
+
+	// 未携带cookie时无状态回复HelloRetry
+	retry, err := handshake.Unmarshal(exchange(), handshake.TypHelloRetry)
+	if err != nil {
+		t.Fatal("want HelloRetry", err)
+	}
+	if peers() != 0 {
+		t.Fatal("peer state allocated before cookie")
+	}
+	// 无效cookie被丢弃
+	clientHello.SetExtension(handshake.ExtCookie, make([]byte, 20))
+	if resp := exchange(); resp != nil || peers() != 0 {
+		t.Fatal("invalid cookie accepted")
+	}
+	// 握手中的对端达到上限时丢弃
+	clientHello.SetExtension(handshake.ExtCookie, retry.Extension(handshake.ExtCookie))
+	l.mu.Lock()
+	l.pending = maxPendingPeers
+	l.mu.Unlock()
+	if resp := exchange(); resp != nil || peers() != 0 {
+		t.Fatal("pending peers not capped")
+	}
+	l.mu.Lock()
+	l.pending = 0
+	l.mu.Unlock()
+	if _, err = handshake.Unmarshal(exchange(), handshake.TypServerHello); err != nil {
+		t.Fatal("want ServerHello", err)
+	}
+	if peers() != 1 {
+		t.Fatal("want peer state", peers())
+	}
+}
+
+// recordingProxy 转发UDP datagram并记录双向的datagram
+type recordingProxy struct {
+	mu       sync.Mutex
+	upstream [][]byte // 客户端发往服务端
+	down     [][]byte // 服务端发往客户端
+}
+
+func (p *recordingProxy) listen(t *testing.T, serverAddr string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := net.Dial("udp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		up.Close()
+	})
+	clientAddr := make(chan net.Addr, 1)
+	go func() {
+		buf := make([]byte, 64<<10)
+		for first := true; ; first = false {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if first {
+				clientAddr <- addr
+			}
+			p.mu.Lock()
+			p.upstream = append(p.upstream, append([]byte(nil), buf[:n]...))
+			p.mu.Unlock()
+			up.Write(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64<<10)
+		addr := <-clientAddr
+		for {
+			n, err := up.Read(buf)
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.down = append(p.down, append([]byte(nil), buf[:n]...))
+			p.mu.Unlock()
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func Test_DatagramUnlinkable(t *testing.T) {
+	l, err := ListenDatagram("udp", "127.0.0.1:0", &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	proxy := &recordingProxy{}
+	c, err := DialDatagram("udp", proxy.listen(t, l.Addr().String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	d := c.(*conn.Datagram)
+	buf := make([]byte, 64<<10)
+	echo := func(msg string) {
+		t.Helper()
+		d.Write([]byte(msg))
+		d.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := d.Read(buf); err != nil || string(buf[:n]) != msg {
+			t.Fatal("unexpected echo", string(buf[:n]), err)
+		}
+	}
+	first := d.ConnectionID()
+	echo("before")
+	if err = d.RotateConnectionID(); err != nil {
+		t.Fatal(err)
+	}
+	second := d.ConnectionID()
+	echo("after")
+
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	// connection ID不出现在明文握手中
+	for _, data := range proxy.down {
+		if bytes.Contains(data, first) || bytes.Contains(data, second) {
+			t.Fatal("connection id sent in plaintext")
+		}
+	}
+	// 轮换后序列号不与之前衔接
+	var lastFirst, firstSecond uint32
+	for _, data := range proxy.upstream {
+		cid, packet := record.SplitConnectionID(data)
+		_, _, seq, err := record.ParseDatagram(packet)
+		if cid == nil || err != nil {
+			continue
+		}
+		if bytes.Equal(cid, first) {
+			lastFirst = seq
+		} else if bytes.Equal(cid, second) && firstSecond == 0 {
+			firstSecond = seq
+		}
+	}
+	if firstSecond <= lastFirst+1 {
+		t.Fatal("sequence continues across rotation", lastFirst, firstSecond)
+	}
+}
@@ -0,0 +1,143 @@
+package wdals
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// detRand 可复现的随机源: sha256(seed+counter)
+type detRand struct {
+	seed    string
+	counter uint64
+	buf     []byte
+}
+
+func (r *detRand) Read(b []byte) (int, error) {
+	for len(r.buf) < len(b) {
+		block := sha256.Sum256(binary.BigEndian.AppendUint64([]byte(r.seed), r.counter))
+		r.buf = append(r.buf, block[:]...)
+		r.counter++
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// recordingServer 记录协议收发数据
+type recordingServer struct {
+	Server
+	transcript *strings.Builder
+}
+
+func (s recordingServer) Handle(r io.Reader) ([]byte, error) {
+	req, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Server.Handle(bytes.NewReader(req))
+	s.transcript.WriteString("C " + hex.EncodeToString(req) + "\n")
+	s.transcript.WriteString("S " + hex.EncodeToString(resp) + "\n")
+	return resp, err
+}
+
+func Test_DeterministicTranscript(t *testing.T) {
+	clock := fixedClock(time.Unix(1700000000, 0))
+	var transcript, keyLog strings.Builder
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder: newTestEncoder(),
+		Clock:         clock,
+		Rand:          &detRand{seed: "server"},
+		KeyLogWriter:  &keyLog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, "", &ClientConfig{
+		Clock:     clock,
+		Rand:      &detRand{seed: "client"},
+		Transport: NewMemoryTransport(recordingServer{Server: srv, transcript: &transcript}),
+	})
+	if err = c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Request([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 收发数据之后附上服务端key log，便于其他语言实现对照
+	got := transcript.String() + keyLog.String()
+	golden := "testdata/transcript.golden"
+	if *update {
+		if err = os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("transcript mismatch, run go test -update\ngot:\n%s", got)
+	}
+}
+
+// failingRand 从detRand读取budget字节后返回错误
+type failingRand struct {
+	detRand
+	budget int
+}
+
+func (r *failingRand) Read(b []byte) (int, error) {
+	if len(b) > r.budget {
+		return 0, errors.New("entropy exhausted")
+	}
+	r.budget -= len(b)
+	return r.detRand.Read(b)
+}
+
+func Test_RandFailure(t *testing.T) {
+	// 随机源在握手各阶段失败时返回错误而不是panic，budget足够时成功
+	for _, side := range []string{"server", "client"} {
+		ok := false
+		for budget := 0; budget <= 256 && !ok; budget += 8 {
+			serverConfig := &ServerConfig{TicketEncoder: newTestEncoder(), SingleUseTicket: true}
+			clientConfig := &ClientConfig{}
+			rand := &failingRand{detRand: detRand{seed: side}, budget: budget}
+			if side == "server" {
+				serverConfig.Rand = rand
+			} else {
+				clientConfig.Rand = rand
+			}
+			srv, err := NewServer(serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig.Transport = NewMemoryTransport(srv)
+			c := newTestClient(t, "", clientConfig)
+			if err = c.Handshake(); err == nil {
+				_, err = c.Request([]byte("ping"))
+			}
+			ok = err == nil
+		}
+		if !ok {
+			t.Fatal(side, "handshake never succeeded")
+		}
+	}
+}
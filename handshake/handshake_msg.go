@@ -14,23 +14,56 @@ const (
 	TypClientHello      handshakeTyp = 1
 	TypServerHello      handshakeTyp = 2
 	TypNewSessionTicket handshakeTyp = 4
+	TypHelloRetry       handshakeTyp = 6
+	TypConnectionIDs    handshakeTyp = 8  // 握手响应中加密下发的datagram connection ID列表
+	TypPathChallenge    handshakeTyp = 9  // datagram会话中服务端向客户端新地址发送的路径挑战
+	TypPathResponse     handshakeTyp = 10 // 客户端原样返回路径挑战数据
 )
 
+// 扩展类型，附加在cipherKey之后: [type:1][length:2][data]
+const (
+	ExtCookie       uint8 = 1
+	ExtIdentity     uint8 = 2
+	ExtServerName   uint8 = 3
+	ExtSignature    uint8 = 4
+	ExtStream       uint8 = 5 // PSK请求流式收发，双方以加密的close_notify结束
+	ExtKeyShare     uint8 = 6 // PSK_DHE临时公钥
+	ExtConnectionID uint8 = 7 // datagram connection ID，ClientHello中表示支持，ServerHello中表示已分配，ID列表加密下发
+)
+
+type extension struct {
+	typ  uint8
+	data []byte
+}
+
 // handshakeMsg
 type handshakeMsg struct {
 	nonce       []byte
 	ts          uint32
 	cipherSuite uint8
 	cipherKey   []byte
+	extensions  []extension
 }
 
-func NewMsg(ts uint32, cipherKey []byte, cipherSuite uint8) *handshakeMsg {
+// NewMsg nonce从rand读取
+func NewMsg(rand io.Reader, ts uint32, cipherKey []byte, cipherSuite uint8) (*handshakeMsg, error) {
+	nonce, err := util.RandomFrom(rand, 32)
+	if err != nil {
+		return nil, err
+	}
 	return &handshakeMsg{
-		nonce:       util.Random(32),
+		nonce:       nonce,
 		ts:          ts,
 		cipherSuite: cipherSuite,
 		cipherKey:   cipherKey,
+	}, nil
+}
+
+func (m *handshakeMsg) Nonce() []byte {
+	if m == nil {
+		return nil
 	}
+	return m.nonce
 }
 
 func (m *handshakeMsg) CipherSuite() uint8 {
@@ -46,6 +79,30 @@ func (m *handshakeMsg) CipherKey() []byte {
 	return m.cipherKey
 }
 
+// Extension 返回扩展数据，不存在时返回nil
+func (m *handshakeMsg) Extension(typ uint8) []byte {
+	if m == nil {
+		return nil
+	}
+	for _, ext := range m.extensions {
+		if ext.typ == typ {
+			return ext.data
+		}
+	}
+	return nil
+}
+
+// SetExtension 设置扩展，已存在时覆盖
+func (m *handshakeMsg) SetExtension(typ uint8, data []byte) {
+	for i, ext := range m.extensions {
+		if ext.typ == typ {
+			m.extensions[i].data = data
+			return
+		}
+	}
+	m.extensions = append(m.extensions, extension{typ: typ, data: data})
+}
+
 func (m *handshakeMsg) Marshal(typ handshakeTyp) []byte {
 	hello := make([]byte, 0, 8+len(m.nonce)+len(m.cipherKey))              // total length
 	hello = append(hello, typ)                                             // handshake type
@@ -53,7 +110,13 @@ func (m *handshakeMsg) Marshal(typ handshakeTyp) []byte {
 	hello = binary.BigEndian.AppendUint32(hello, m.ts)                     // timestamp
 	hello = append(hello, m.cipherSuite)                                   // cipher suite type
 	hello = binary.BigEndian.AppendUint16(hello, uint16(len(m.cipherKey))) // cipher key
-	return append(hello, m.cipherKey...)
+	hello = append(hello, m.cipherKey...)
+	for _, ext := range m.extensions { // extensions
+		hello = append(hello, ext.typ)
+		hello = binary.BigEndian.AppendUint16(hello, uint16(len(ext.data)))
+		hello = append(hello, ext.data...)
+	}
+	return hello
 }
 
 func Unmarshal(data []byte, typ handshakeTyp) (_ *handshakeMsg, err error) {
@@ -84,16 +147,30 @@ func Unmarshal(data []byte, typ handshakeTyp) (_ *handshakeMsg, err error) {
 		return
 	}
 	cipherKey := make([]byte, keyLen)
-	if _, err = r.Read(cipherKey); err != nil {
-		return
-	}
-	if r.Len() != 0 {
+	if _, err = io.ReadFull(r, cipherKey); err != nil {
 		return nil, util.ErrDataCorrupted
 	}
+	var extensions []extension
+	for r.Len() != 0 {
+		var ext extension
+		var extLen uint16
+		if ext.typ, err = r.ReadByte(); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &extLen); err != nil {
+			return nil, util.ErrDataCorrupted
+		}
+		ext.data = make([]byte, extLen)
+		if _, err = io.ReadFull(r, ext.data); err != nil {
+			return nil, util.ErrDataCorrupted
+		}
+		extensions = append(extensions, ext)
+	}
 	return &handshakeMsg{
 		nonce:       nonce,
 		ts:          ts,
 		cipherSuite: cipherSuite,
 		cipherKey:   cipherKey,
+		extensions:  extensions,
 	}, nil
 }
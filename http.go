@@ -0,0 +1,195 @@
+package wdals
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/ryanx-sir/simple-als/server"
+	"github.com/ryanx-sir/simple-als/ticket"
+)
+
+// MaxHTTPBody 单次HTTP请求承载的协议数据上限
+const MaxHTTPBody = 4 << 20
+
+// peerServer NewServer返回的Server均实现，传入请求来源
+type peerServer interface {
+	HandleFrom(peer *Peer, r io.Reader) ([]byte, error)
+	HandleStreamFrom(peer *Peer, r io.Reader, w io.Writer) error
+}
+
+// NewHTTPHandler 通过HTTP承载协议，ClientHello可以放在GET参数hello中，也可以作为POST请求体
+// srv实现StreamServer时流式请求边处理边响应，POST请求体包括流式请求都不超过MaxHTTPBody
+// srv为NewServer返回的Server时传入http.Request.RemoteAddr，位于反向代理之后时为代理地址
+func NewHTTPHandler(srv Server) http.Handler {
+	streamSrv, stream := srv.(StreamServer)
+	peerSrv, _ := srv.(peerServer)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := &Peer{Context: r.Context(), RemoteAddr: r.RemoteAddr}
+		var body io.Reader
+		if hello := r.URL.Query().Get("hello"); hello != "" {
+			data, err := base64.RawURLEncoding.DecodeString(hello)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = bytes.NewReader(data)
+		} else if r.Method == http.MethodPost {
+			body = http.MaxBytesReader(w, r.Body, MaxHTTPBody)
+		} else {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if stream {
+			sw := &streamWriter{ResponseWriter: w}
+			w.Header().Set("Content-Type", "application/x-wdals")
+			// 已写出部分响应时无法再返回错误状态码，客户端由缺少close_notify发现错误
+			var err error
+			if peerSrv != nil {
+				err = peerSrv.HandleStreamFrom(peer, body, sw)
+			} else {
+				err = streamSrv.HandleStream(body, sw)
+			}
+			if err != nil && !sw.written {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		var resp []byte
+		var err error
+		if peerSrv != nil {
+			resp, err = peerSrv.HandleFrom(peer, body)
+		} else {
+			resp, err = srv.Handle(body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-wdals")
+		w.Write(resp)
+	})
+}
+
+// streamWriter 记录是否已写出响应，每次写入后立即发送
+type streamWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *streamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware 通过协议隧道保护已有HTTP API
+// PSK early data携带序列化的内层HTTP请求，交给next处理后，响应序列化加密返回
+// early data可被截获后重放，未开启SingleUseTicket时非幂等请求(POST、PATCH等)返回425 Too Early，不交给next
+// 内层请求使用外层请求的Context和RemoteAddr，本次恢复使用的票据信息由SessionFromContext获取
+func Middleware(config *ServerConfig) (func(next http.Handler) http.Handler, error) {
+	if _, err := NewServer(config); err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		tunnelConfig := *config
+		tunnelConfig.Handler = tunnelHandler(next, config.SingleUseTicket)
+		srv, _ := NewServer(&tunnelConfig) // 配置已校验
+		return NewHTTPHandler(srv)
+	}, nil
+}
+
+// tunnelHandler replayProtected为false时只处理幂等请求
+func tunnelHandler(next http.Handler, replayProtected bool) server.Handler {
+	return server.HandlerFunc(func(req *server.Request) ([]byte, error) {
+		inner, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(req.Data)))
+		if err != nil {
+			return nil, err
+		}
+		inner = inner.WithContext(context.WithValue(req.Hello.Context(), sessionContextKey{}, req.Session))
+		inner.RemoteAddr = req.Hello.RemoteAddr
+		rec := &responseRecorder{header: make(http.Header)}
+		if !replayProtected && !idempotent(inner.Method) {
+			http.Error(rec, http.StatusText(http.StatusTooEarly), http.StatusTooEarly)
+		} else {
+			next.ServeHTTP(rec, inner)
+		}
+		return rec.marshal(inner)
+	})
+}
+
+// sessionContextKey Middleware内层请求Context中票据信息的key
+type sessionContextKey struct{}
+
+// SessionFromContext Middleware交给next的请求中本次恢复使用的票据信息，如Identity、AppData
+func SessionFromContext(ctx context.Context) (*ticket.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(*ticket.Session)
+	return session, ok && session != nil
+}
+
+// idempotent 重放不改变结果的HTTP方法，RFC 9110 9.2.2
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// responseRecorder 记录内层handler的响应
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) marshal(req *http.Request) ([]byte, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	resp := &http.Response{
+		Status:        http.StatusText(r.status),
+		StatusCode:    r.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.header,
+		Body:          io.NopCloser(&r.body),
+		ContentLength: int64(r.body.Len()),
+		Request:       req,
+	}
+	if resp.Header.Get("Content-Type") == "" && r.body.Len() > 0 {
+		resp.Header.Set("Content-Type", http.DetectContentType(r.body.Bytes()))
+	}
+	resp.Header.Del("Transfer-Encoding")
+	var buf bytes.Buffer
+	if err := resp.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,194 @@
+package wdals
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/ticket"
+)
+
+func newTestEncoder() *ticket.Encoder {
+	return ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}})
+}
+
+func newTestMiddleware(t *testing.T, api http.Handler) *httptest.Server {
+	middleware, err := Middleware(&ServerConfig{TicketEncoder: newTestEncoder(), SingleUseTicket: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(middleware(api))
+}
+
+func newTestClient(t *testing.T, host string, config *ClientConfig) AlClient {
+	c, err := NewClient(host, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_Middleware(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"path":"` + r.URL.Path + `","body":` + string(body) + `}`))
+	})
+	hs := newTestMiddleware(t, api)
+	defer hs.Close()
+
+	c := newTestClient(t, hs.URL+"/wdals", nil)
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "http://api.local/users", bytes.NewReader([]byte(`{"name":"a"}`)))
+	var buf bytes.Buffer
+	req.Write(&buf)
+	data, err := c.Request(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal("unexpected response", resp.Status, resp.Header)
+	}
+	if string(body) != `{"path":"/users","body":{"name":"a"}}` {
+		t.Fatal("unexpected body", string(body))
+	}
+}
+
+func Test_HTTPTransportHeader(t *testing.T) {
+	srv, err := NewServer(&ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPHandler(srv)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+
+	c := newTestClient(t, "", &ClientConfig{Transport: &HTTPTransport{
+		URL:    hs.URL,
+		Client: hs.Client(),
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	}})
+	if err = c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Request([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordTransport 记录最近一次PSK请求，模拟截获early data
+type recordTransport struct {
+	ClientTransport
+	last []byte
+}
+
+func (t *recordTransport) Exchange(data []byte, hello bool) ([]byte, error) {
+	if !hello {
+		t.last = data
+	}
+	return t.ClientTransport.Exchange(data, hello)
+}
+
+func Test_MiddlewareSession(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := SessionFromContext(r.Context())
+		if !ok {
+			http.Error(w, "no session", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(session.Identity))
+	})
+	hs := newTestMiddleware(t, api)
+	defer hs.Close()
+
+	transport, err := NewTransport(hs.URL+"/wdals", &ClientConfig{Identity: "device-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://api.local/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "device-1" {
+		t.Fatal("unexpected response", resp.StatusCode, string(body))
+	}
+}
+
+func Test_MiddlewareReplay(t *testing.T) {
+	var calls atomic.Int32
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.RemoteAddr == "" || r.Context().Done() == nil {
+			t.Error("inner request missing RemoteAddr or Context")
+		}
+	})
+	for _, singleUse := range []bool{false, true} {
+		middleware, err := Middleware(&ServerConfig{TicketEncoder: newTestEncoder(), SingleUseTicket: singleUse})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs := httptest.NewServer(middleware(api))
+		transport := &recordTransport{ClientTransport: &HTTPTransport{URL: hs.URL, Client: hs.Client()}}
+		c := newTestClient(t, "", &ClientConfig{Transport: transport})
+		request := func(method string) int {
+			req, _ := http.NewRequest(method, "http://api.local/orders", nil)
+			var buf bytes.Buffer
+			req.Write(&buf)
+			data, err := c.Request(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}
+
+		calls.Store(0)
+		if status := request(http.MethodGet); status != http.StatusOK || calls.Load() != 1 {
+			t.Fatal("unexpected GET", status, calls.Load())
+		}
+		status := request(http.MethodPost)
+		if !singleUse {
+			// 无防重放时不处理非幂等请求
+			if status != http.StatusTooEarly || calls.Load() != 1 {
+				t.Fatal("want 425 Too Early", status, calls.Load())
+			}
+			hs.Close()
+			continue
+		}
+		if status != http.StatusOK || calls.Load() != 2 {
+			t.Fatal("unexpected POST", status, calls.Load())
+		}
+		// 重放截获的请求，票据已被使用，handler不再执行
+		if _, err = transport.ClientTransport.Exchange(transport.last, false); err != nil {
+			t.Fatal(err)
+		}
+		if calls.Load() != 2 {
+			t.Fatal("replayed request handled", calls.Load())
+		}
+		hs.Close()
+	}
+}
@@ -0,0 +1,171 @@
+package wdals
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/client"
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/server"
+)
+
+// HandshakeTimeout 流式连接握手超时时间
+const HandshakeTimeout = 10 * time.Second
+
+// ErrConnCipherSuite 连接握手只支持DHE_SECP256R1_WITH_AES_GCM，ClientConfig.CipherSuites未包含时返回
+var ErrConnCipherSuite = errors.New("conn handshake only supports DHE_SECP256R1_WITH_AES_GCM")
+
+type connServer interface {
+	HandshakeConn(net.Conn) (*conn.Conn, error)
+}
+
+// listener Accept返回已完成服务端握手的连接
+type listener struct {
+	net.Listener
+	server connServer
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	err       error
+}
+
+// Listen 监听并返回加密listener，现有TCP服务替换listener即可接入
+func Listen(network, addr string, config *ServerConfig) (net.Listener, error) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	sl, err := NewListener(l, config)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return sl, nil
+}
+
+// NewListener 包装已有listener，握手在后台完成，慢客户端不会阻塞Accept
+func NewListener(inner net.Listener, config *ServerConfig) (net.Listener, error) {
+	srv, err := server.NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		Listener: inner,
+		server:   srv,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// serve 内层listener关闭时退出，其他Accept错误(如EMFILE)等待后重试，等待时间从5ms加倍到1s
+func (l *listener) serve() {
+	var delay time.Duration
+	for {
+		c, err := l.Listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			l.Close()
+			return
+		}
+		if err != nil {
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-l.done:
+				t.Stop()
+				return
+			}
+			continue
+		}
+		delay = 0
+		go l.handshake(c)
+	}
+}
+
+func (l *listener) handshake(c net.Conn) {
+	c.SetDeadline(time.Now().Add(HandshakeTimeout))
+	sc, err := l.server.HandshakeConn(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+	select {
+	case l.conns <- sc:
+	case <-l.done:
+		sc.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+	return
+}
+
+// Dial 建立连接并完成客户端握手，config.SessionCache中有可用票据时PSK恢复会话
+// config.CipherSuites非空时须包含DHE_SECP256R1_WITH_AES_GCM，否则返回ErrConnCipherSuite
+func Dial(network, addr string, config *ClientConfig) (net.Conn, error) {
+	if err := connSuite(config); err != nil {
+		return nil, err
+	}
+	alClient, err := client.NewAesGcmClient(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.DialTimeout(network, addr, HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(HandshakeTimeout))
+	cc, err := alClient.Conn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return cc, nil
+}
+
+// connSuite 连接握手只实现了DHE_SECP256R1_WITH_AES_GCM，不静默替换调用方指定的套件
+func connSuite(config *ClientConfig) error {
+	if config == nil || len(config.CipherSuites) == 0 {
+		return nil
+	}
+	for _, suite := range config.CipherSuites {
+		if suite == DHE_SECP256R1_WITH_AES_GCM {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrConnCipherSuite, config.CipherSuites)
+}
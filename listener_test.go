@@ -0,0 +1,187 @@
+package wdals
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/ryanx-sir/simple-als/client"
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+func Test_Listener(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", &ServerConfig{
+		TicketEncoder: newTestEncoder(),
+		Retry:         &RetryConfig{Secret: []byte("cookie secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	msg := bytes.Repeat([]byte("ping"), 10000) // 跨多个record
+	go c.Write(msg)
+	echo := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, echo); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, echo) {
+		t.Fatal("echo mismatch")
+	}
+}
+
+// resumeCounter 统计客户端成功的PSK会话恢复
+type resumeCounter struct{ resumed int32 }
+
+func (r *resumeCounter) ObserveHandshake(e util.HandshakeEvent) {
+	if e.Resumed && e.Err == nil {
+		atomic.AddInt32(&r.resumed, 1)
+	}
+}
+
+func Test_ListenerResume(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", &ServerConfig{
+		TicketEncoder:   newTestEncoder(),
+		SingleUseTicket: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				data, err := io.ReadAll(c) // 读到客户端close_notify
+				if err != nil {
+					return
+				}
+				if string(data) == "truncate" {
+					c.Write(data)
+					c.(*conn.Conn).Conn.Close() // 不发送close_notify直接断开
+					return
+				}
+				c.Write(data)
+				c.(*conn.Conn).CloseWrite()
+			}()
+		}
+	}()
+
+	counter := &resumeCounter{}
+	config := &ClientConfig{SessionCache: client.NewMemorySessionCache(), Observer: counter}
+	exchange := func(msg []byte) ([]byte, error) {
+		c, err := Dial("tcp", l.Addr().String(), config)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		if _, err = c.Write(msg); err != nil {
+			return nil, err
+		}
+		if err = c.(*conn.Conn).CloseWrite(); err != nil {
+			return nil, err
+		}
+		if _, err = c.Write(msg); err != record.ErrWriteClosed {
+			t.Fatal("expected write after close_notify to fail", err)
+		}
+		return io.ReadAll(c)
+	}
+	// 首次完整握手，之后使用单次票据恢复会话，连接上下发的新票据供下次使用
+	msg := bytes.Repeat([]byte("ping"), 10000)
+	for i := 0; i < 3; i++ {
+		echo, err := exchange(msg)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(msg, echo) {
+			t.Fatal("echo mismatch")
+		}
+	}
+	if counter.resumed != 2 {
+		t.Fatal("expected 2 resumed sessions, got", counter.resumed)
+	}
+
+	if _, err = exchange([]byte("truncate")); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected truncation to be detected", err)
+	}
+}
+
+// flakyListener 前几次Accept返回错误，模拟文件描述符耗尽
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, syscall.EMFILE
+	}
+	return l.Listener.Accept()
+}
+
+func Test_ListenerAcceptRetry(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyListener{Listener: inner}
+	flaky.failures.Store(3)
+	l, err := NewListener(flaky, &ServerConfig{TicketEncoder: newTestEncoder()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err = io.ReadFull(c, echo); err != nil || string(echo) != "ping" {
+		t.Fatal("unexpected echo", string(echo), err)
+	}
+
+	l.Close()
+	if _, err = l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("want net.ErrClosed", err)
+	}
+}
+
+func Test_DialCipherSuite(t *testing.T) {
+	_, err := Dial("tcp", "127.0.0.1:0", &ClientConfig{CipherSuites: []uint8{DHE_X25519_WITH_XSALSA20_POLY1305}})
+	if !errors.Is(err, ErrConnCipherSuite) {
+		t.Fatal("want ErrConnCipherSuite", err)
+	}
+}
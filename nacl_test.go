@@ -0,0 +1,45 @@
+package wdals
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_NaclClient(t *testing.T) {
+	publicKey, identityKey, _ := ed25519.GenerateKey(nil)
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder:   newTestEncoder(),
+		IdentityKey:     identityKey,
+		Retry:           &RetryConfig{Secret: []byte("cookie secret")},
+		SingleUseTicket: true,
+		Handler: HandlerFunc(func(req *Request) ([]byte, error) {
+			return append([]byte(req.Session.Identity+":"), req.Data...), nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(NewHTTPHandler(srv))
+	defer hs.Close()
+
+	c := newTestClient(t, hs.URL, &ClientConfig{
+		CipherSuites: []uint8{DHE_X25519_WITH_XSALSA20_POLY1305},
+		Identity:     "device-1",
+		ServerKey:    publicKey,
+	})
+	if err = c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// 单次使用票据，每次请求都换新票据
+	big := make([]byte, 40<<10)
+	for i := 0; i < 3; i++ {
+		resp, err := c.Request(big)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if len(resp) != len("device-1:")+len(big) || string(resp[:9]) != "device-1:" {
+			t.Fatal("unexpected response", len(resp))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package wdals
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func Test_PskDhe(t *testing.T) {
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder:   newTestEncoder(),
+		SingleUseTicket: true,
+		RequirePskDhe:   true,
+		Handler: HandlerFunc(func(req *Request) ([]byte, error) {
+			return append([]byte("echo:"), req.Data...), nil
+		}),
+		StreamHandler: StreamHandlerFunc(func(w io.Writer, req *StreamRequest) error {
+			_, err := io.Copy(w, req.Body)
+			return err
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suite := range []uint8{DHE_SECP256R1_WITH_AES_GCM, DHE_X25519_WITH_XSALSA20_POLY1305} {
+		// 未携带key share的PSK请求被拒绝
+		c := newTestClient(t, "", &ClientConfig{CipherSuites: []uint8{suite}, Transport: NewMemoryTransport(srv)})
+		if _, err = c.Request([]byte("hello")); err == nil {
+			t.Fatal("expected psk_dhe required error")
+		}
+
+		c = newTestClient(t, "", &ClientConfig{CipherSuites: []uint8{suite}, Transport: NewMemoryTransport(srv), PskDhe: true})
+		// 单次使用票据，后续请求使用PSK_DHE响应中下发的新票据
+		for i := 0; i < 3; i++ {
+			resp, err := c.Request([]byte("hello"))
+			if err != nil {
+				t.Fatal(suite, i, err)
+			}
+			if string(resp) != "echo:hello" {
+				t.Fatal("unexpected response", string(resp))
+			}
+		}
+		resp, err := c.RequestStream(bytes.NewReader([]byte("stream")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp)
+		resp.Close()
+		if err != nil || string(data) != "stream" {
+			t.Fatal("unexpected stream response", string(data), err)
+		}
+		if _, err = c.Request([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
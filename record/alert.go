@@ -0,0 +1,24 @@
+package record
+
+import "errors"
+
+// 告警描述，告警record明文发送: [description:1]
+const (
+	AlertCloseNotify    uint8 = 0 // 数据发送完毕，加密发送，用于检测截断
+	AlertTicketRejected uint8 = 1 // 票据无法使用，early data未被处理
+)
+
+var ErrAlert = errors.New("alert record")
+
+// NewAlert 告警record，version与请求一致
+func NewAlert(version, description uint8) *record {
+	return newRecord(TypeAlert, version, []byte{description})
+}
+
+// Alert 返回告警描述，非告警record返回false
+func (r *record) Alert() (uint8, bool) {
+	if r.typ != TypeAlert || len(r.data) != 1 {
+		return 0, false
+	}
+	return r.data[0], true
+}
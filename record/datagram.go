@@ -0,0 +1,105 @@
+package record
+
+import (
+	"encoding/binary"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// DatagramHeaderLen datagram record头 [typ:1][version:1][epoch:2][seq:4][len:2]
+// 每个datagram承载一个record，epoch 0为明文握手flight，epoch 1为流量密钥加密的数据
+const DatagramHeaderLen = 10
+
+// MarshalDatagram 携带显式epoch和序列号，接收方不依赖到达顺序即可解密
+func (r *record) MarshalDatagram(epoch uint16, seq uint32) []byte {
+	buf := make([]byte, DatagramHeaderLen+int(r.length))
+
+	buf[0] = r.typ
+	buf[1] = r.version
+	binary.BigEndian.PutUint16(buf[2:], epoch)
+	binary.BigEndian.PutUint32(buf[4:], seq)
+	binary.BigEndian.PutUint16(buf[8:], r.length)
+	copy(buf[DatagramHeaderLen:], r.data)
+
+	return buf
+}
+
+// ParseDatagram 解析单个datagram record，长度与头部不一致时返回util.ErrDataCorrupted
+func ParseDatagram(b []byte) (r *record, epoch uint16, seq uint32, err error) {
+	if len(b) < DatagramHeaderLen {
+		return nil, 0, 0, util.ErrDataCorrupted
+	}
+	r = &record{
+		typ:     b[0],
+		version: b[1],
+		length:  binary.BigEndian.Uint16(b[8:]),
+	}
+	if len(b) != DatagramHeaderLen+int(r.length) {
+		return nil, 0, 0, util.ErrDataCorrupted
+	}
+	r.data = append([]byte(nil), b[DatagramHeaderLen:]...)
+	return r, binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[4:]), nil
+}
+
+// ConnectionIDLen connection ID长度
+const ConnectionIDLen = 8
+
+// WithConnectionID datagram前加上connection ID，接收方据此路由而不依赖来源地址
+func WithConnectionID(cid, datagram []byte) []byte {
+	buf := make([]byte, 0, 1+len(cid)+len(datagram))
+	buf = append(buf, TypeConnectionID)
+	buf = append(buf, cid...)
+	return append(buf, datagram...)
+}
+
+// SplitConnectionID 拆分connection ID和datagram record，不带connection ID时cid为nil
+func SplitConnectionID(b []byte) (cid, datagram []byte) {
+	if len(b) < 1+ConnectionIDLen || b[0] != TypeConnectionID {
+		return nil, b
+	}
+	return b[1 : 1+ConnectionIDLen], b[1+ConnectionIDLen:]
+}
+
+// ReplayWindowSize 防重放窗口大小，比已收到最大序列号小该值以上的record被丢弃
+const ReplayWindowSize = 64
+
+// ReplayWindow 滑动窗口防重放，容忍窗口内的乱序到达
+// 先Check再解密，解密成功后Accept，伪造的record不会推动窗口
+type ReplayWindow struct {
+	latest uint32
+	bitmap uint64 // 第i位表示latest-i已收到
+	init   bool
+}
+
+// Check 序列号未收到过且未落后于窗口
+func (w *ReplayWindow) Check(seq uint32) bool {
+	if !w.init || seq > w.latest {
+		return true
+	}
+	diff := w.latest - seq
+	if diff >= ReplayWindowSize {
+		return false
+	}
+	return w.bitmap&(1<<diff) == 0
+}
+
+// Accept 标记序列号已收到，需先通过Check，返回是否为目前最大的序列号
+func (w *ReplayWindow) Accept(seq uint32) (latest bool) {
+	if !w.init {
+		w.latest, w.bitmap, w.init = seq, 1, true
+		return true
+	}
+	if seq > w.latest {
+		shift := seq - w.latest
+		if shift >= ReplayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.latest = seq
+		return true
+	}
+	w.bitmap |= 1 << (w.latest - seq)
+	return false
+}
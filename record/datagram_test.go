@@ -0,0 +1,50 @@
+package record
+
+import "testing"
+
+func Test_ReplayWindow(t *testing.T) {
+	var w ReplayWindow
+	accept := func(seq uint32, want bool) {
+		t.Helper()
+		if got := w.Check(seq); got != want {
+			t.Fatalf("Check(%d) = %v, want %v", seq, got, want)
+		}
+		if want {
+			w.Accept(seq)
+		}
+	}
+	accept(5, true)
+	accept(5, false) // 重放
+	accept(3, true)  // 乱序到达
+	accept(3, false)
+	accept(100, true)
+	accept(36, false) // 落后于窗口
+	accept(37, true)
+	accept(99, true)
+	accept(99, false)
+	accept(1000, true)
+	accept(100, false)
+}
+
+func Test_Datagram(t *testing.T) {
+	rec := New(TypeApplicationData, ProtocolAesGcm, []byte("telemetry"))
+	key := make([]byte, KeySize(ProtocolAesGcm))
+	if err := rec.Seal(key, 7); err != nil {
+		t.Fatal(err)
+	}
+	out := rec.MarshalDatagram(1, 7)
+	parsed, epoch, seq, err := ParseDatagram(out)
+	if err != nil || epoch != 1 || seq != 7 {
+		t.Fatal("unexpected header", epoch, seq, err)
+	}
+	if err = parsed.Open(key, seq); err != nil || string(parsed.GetData()) != "telemetry" {
+		t.Fatal("open failed", err)
+	}
+	if _, _, _, err = ParseDatagram(out[:len(out)-1]); err == nil {
+		t.Fatal("expected truncated datagram to fail")
+	}
+	parsed, _, _, _ = ParseDatagram(out)
+	if err = parsed.Open(key, 8); err == nil {
+		t.Fatal("expected sequence mismatch to fail")
+	}
+}
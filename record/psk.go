@@ -0,0 +1,45 @@
+package record
+
+import "github.com/ryanx-sir/simple-als/util"
+
+// SuiteVersion PSK套件对应的record版本
+func SuiteVersion(suite uint8) uint8 {
+	if suite == util.PSK_WITH_XSALSA20_POLY1305 || suite == util.DHE_X25519_WITH_XSALSA20_POLY1305 {
+		return ProtocolXsalsa20Poly1305
+	}
+	return ProtocolAesGcm
+}
+
+// KeySize PSK派生的[key+nonce]长度
+func KeySize(version uint8) int {
+	if version == ProtocolXsalsa20Poly1305 {
+		return 56 // [key:32+nonce:24]
+	}
+	return 28 // [key:16+nonce:12]
+}
+
+func New(typ recordTyp, version uint8, data []byte) *record {
+	return newRecord(typ, version, data)
+}
+
+// Seal 按record版本加密，key长度为KeySize(version)
+func (r *record) Seal(key []byte, seqNum uint32) error {
+	if len(key) != KeySize(r.version) {
+		return ErrRecordVersion
+	}
+	if r.version == ProtocolXsalsa20Poly1305 {
+		return r.SecretboxSeal(*(*[56]byte)(key), seqNum)
+	}
+	return r.AesGcmEncrypt(*(*[28]byte)(key), seqNum)
+}
+
+// Open 按record版本解密
+func (r *record) Open(key []byte, seqNum uint32) error {
+	if len(key) != KeySize(r.version) {
+		return ErrRecordVersion
+	}
+	if r.version == ProtocolXsalsa20Poly1305 {
+		return r.SecretboxOpen(*(*[56]byte)(key), seqNum)
+	}
+	return r.AesGcmDecrypt(*(*[28]byte)(key), seqNum)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/ryanx-sir/simple-als/util"
 	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
 	"io"
 )
 
@@ -13,6 +14,9 @@ type recordTyp = uint8
 const ProtocolAesGcm uint8 = 0b01
 const ProtocolXsalsa20Poly1305 uint8 = 0b10
 
+// MaxPlaintext 单个record最大明文长度
+const MaxPlaintext = 16 << 10
+
 var ErrRecordVersion = errors.New("record version error")
 
 const (
@@ -20,6 +24,7 @@ const (
 	TypeAlert
 	TypeHandshake
 	TypeApplicationData
+	TypeConnectionID // datagram前缀 [TypeConnectionID][cid]，后接datagram record
 )
 
 // todo add record interface
@@ -62,7 +67,7 @@ func ReadNew(buf io.Reader) (*record, error) {
 		return nil, err
 	}
 	r.data = make([]byte, r.length)
-	if _, err := buf.Read(r.data); err != nil {
+	if _, err := io.ReadFull(buf, r.data); err != nil {
 		return nil, err
 	}
 
@@ -159,7 +164,44 @@ func (r *record) NaclUnbox(nonce *[24]byte, peersPublicKey, privateKey *[32]byte
 	}
 	nonce[8] = r.typ
 	nonce[9] = r.version
-	decrypt, _ := box.Open(nil, r.data, nonce, peersPublicKey, privateKey)
+	decrypt, ok := box.Open(nil, r.data, nonce, peersPublicKey, privateKey)
+	if !ok {
+		return util.ErrDataCorrupted
+	}
+	r.data = decrypt
+	r.length = uint16(len(decrypt))
+	return nil
+}
+
+// secretboxNonce nonce = 派生nonce ^ seqNum，并混入record类型和版本
+func (r *record) secretboxNonce(keyPair *[56]byte, seqNum uint32) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], keyPair[32:])
+	util.XorNonce(nonce[:], seqNum)
+	nonce[8] ^= r.typ
+	nonce[9] ^= r.version
+	return &nonce
+}
+
+// SecretboxSeal PSK套件使用，keyPair: [key:32+nonce:24]
+func (r *record) SecretboxSeal(keyPair [56]byte, seqNum uint32) error {
+	if r.version != ProtocolXsalsa20Poly1305 {
+		return ErrRecordVersion
+	}
+	encrypt := secretbox.Seal(nil, r.data, r.secretboxNonce(&keyPair, seqNum), (*[32]byte)(keyPair[:32]))
+	r.data = encrypt
+	r.length = uint16(len(encrypt))
+	return nil
+}
+
+func (r *record) SecretboxOpen(keyPair [56]byte, seqNum uint32) error {
+	if r.version != ProtocolXsalsa20Poly1305 {
+		return ErrRecordVersion
+	}
+	decrypt, ok := secretbox.Open(nil, r.data, r.secretboxNonce(&keyPair, seqNum), (*[32]byte)(keyPair[:32]))
+	if !ok {
+		return util.ErrDataCorrupted
+	}
 	r.data = decrypt
 	r.length = uint16(len(decrypt))
 	return nil
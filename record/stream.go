@@ -0,0 +1,147 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"math"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// ErrSequenceExhausted 序列号用尽，继续收发会重用nonce，需重新建立会话
+var ErrSequenceExhausted = errors.New("record sequence exhausted")
+
+// ErrWriteClosed 已写入close_notify
+var ErrWriteClosed = errors.New("write after close_notify")
+
+// Reader 依次读取并解密record，读到加密的close_notify后返回io.EOF
+// close_notify之前遇到EOF返回io.ErrUnexpectedEOF，即数据被截断
+type Reader struct {
+	// OnHandshake 收到加密的handshake record时回调，为nil时视为数据损坏
+	OnHandshake func(data []byte) error
+
+	r   io.Reader
+	key []byte
+	seq uint32
+	buf []byte
+	err error
+}
+
+func NewReader(r io.Reader, key []byte, seq uint32) *Reader {
+	return &Reader{r: r, key: key, seq: seq}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 && r.err == nil {
+		r.err = r.next()
+	}
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) next() error {
+	if r.seq == math.MaxUint32 {
+		return ErrSequenceExhausted
+	}
+	rec, err := ReadNew(r.r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if err = rec.Open(r.key, r.seq); err != nil {
+		return err
+	}
+	r.seq++
+	switch rec.Type() {
+	case TypeApplicationData:
+		r.buf = rec.GetData()
+		return nil
+	case TypeHandshake:
+		if r.OnHandshake == nil {
+			return util.ErrDataCorrupted
+		}
+		return r.OnHandshake(rec.GetData())
+	case TypeAlert:
+		if alert, _ := rec.Alert(); alert == AlertCloseNotify {
+			return io.EOF
+		}
+	}
+	return ErrAlert
+}
+
+// Writer 数据按MaxPlaintext分块加密写入，Close时写入加密的close_notify
+type Writer struct {
+	// Header 首次写入前输出的明文数据，如ServerHello record
+	Header []byte
+
+	w       io.Writer
+	version uint8
+	key     []byte
+	seq     uint32
+	written bool
+	closed  bool
+}
+
+func NewWriter(w io.Writer, version uint8, key []byte, seq uint32) *Writer {
+	return &Writer{w: w, version: version, key: key, seq: seq}
+}
+
+// Written 是否已向底层写入数据
+func (w *Writer) Written() bool {
+	return w.written
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxPlaintext {
+			chunk = chunk[:MaxPlaintext]
+		}
+		if err = w.WriteRecord(TypeApplicationData, chunk); err != nil {
+			return
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+// WriteRecord 加密写入单个record，底层支持Flush时立即发送
+func (w *Writer) WriteRecord(typ uint8, data []byte) error {
+	if w.closed {
+		return ErrWriteClosed
+	}
+	if w.seq == math.MaxUint32 {
+		return ErrSequenceExhausted
+	}
+	rec := newRecord(typ, w.version, append([]byte(nil), data...))
+	if err := rec.Seal(w.key, w.seq); err != nil {
+		return err
+	}
+	w.seq++
+	out := rec.Marshal()
+	if !w.written {
+		out = append(w.Header, out...)
+		w.written = true
+	}
+	if _, err := w.w.Write(out); err != nil {
+		return err
+	}
+	if f, ok := w.w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Close 写入close_notify，不关闭底层writer，之后的写入返回ErrWriteClosed
+func (w *Writer) Close() error {
+	err := w.WriteRecord(TypeAlert, []byte{AlertCloseNotify})
+	w.closed = true
+	return err
+}
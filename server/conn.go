@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// handshakeKeys 握手完成后派生流量密钥所需的材料
+type handshakeKeys struct {
+	secret     []byte
+	transcript []byte
+}
+
+// HandshakeConn 在流式连接上完成服务端握手或PSK会话恢复，返回加密连接
+// 票据被拒绝时发送告警，客户端可在同一连接上重新完整握手
+func (s *server) HandshakeConn(c net.Conn) (*conn.Conn, error) {
+	var retried, rejected bool
+	for {
+		nowTs := uint32(s.clock.Now().Unix())
+		hello, err := s.readHello(c, peerOf(c))
+		if err != nil {
+			return nil, err
+		}
+		ts, err := s.forClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		switch hello.CipherSuite {
+		case util.PSK_WITH_AES_GCM, util.PSK_WITH_XSALSA20_POLY1305:
+			sc, err := ts.resumeConn(c, hello, nowTs)
+			if !errors.Is(err, ErrTicketRejected) || rejected {
+				return sc, err
+			}
+			rejected = true
+			alert := record.NewAlert(record.SuiteVersion(hello.CipherSuite), record.AlertTicketRejected)
+			if _, err = c.Write(alert.Marshal()); err != nil {
+				return nil, err
+			}
+			continue
+		case util.DHE_SECP256R1_WITH_AES_GCM:
+		default:
+			return nil, fmt.Errorf("cipher(%d) not support", hello.CipherSuite)
+		}
+		retry, err := ts.checkRetry(hello, nowTs)
+		if err != nil {
+			return nil, err
+		}
+		if retry != nil {
+			if retried {
+				return nil, ErrCookieInvalid
+			}
+			retried = true
+			if _, err = c.Write(retry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		done := ts.limiter.begin(nowTs)
+		resp, keys, err := ts.ecdheAesGcm(hello, nowTs)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = c.Write(resp); err != nil {
+			return nil, err
+		}
+		clientKey, serverKey := conn.TrafficKeys(record.ProtocolAesGcm, keys.secret, keys.transcript)
+		util.WriteKeyLog(ts.keyLogWriter, util.KeyLogClientTraffic, hello.Nonce, clientKey)
+		util.WriteKeyLog(ts.keyLogWriter, util.KeyLogServerTraffic, hello.Nonce, serverKey)
+		return conn.New(c, record.ProtocolAesGcm, clientKey, serverKey), nil
+	}
+}
+
+// resumeConn PSK恢复长连接会话，不携带early data
+// 流量密钥由secret和transcript(ClientHello+ServerHello)派生，需要时紧接着下发加密的新票据
+func (s *server) resumeConn(c net.Conn, hello *ClientHelloInfo, nowTs uint32) (*conn.Conn, error) {
+	session, err := s.checkTicket(hello, nowTs)
+	if err != nil {
+		return nil, err
+	}
+	secret, keyShare, err := s.pskDhe(hello, session.TicketKey)
+	if err != nil {
+		return nil, err
+	}
+	version := record.SuiteVersion(hello.CipherSuite)
+
+	// todo 1. sendServerHello
+	serverHello, err := handshake.NewMsg(s.rand, nowTs, hello.CipherKey, hello.CipherSuite)
+	if err != nil {
+		return nil, err
+	}
+	if keyShare != nil {
+		serverHello.SetExtension(handshake.ExtKeyShare, keyShare)
+	}
+	record1 := record.New(record.TypeHandshake, version, serverHello.Marshal(handshake.TypServerHello))
+	if _, err = c.Write(record1.Marshal()); err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	hasher.Write(hello.raw)
+	hasher.Write(record1.GetData())
+	transcript := hasher.Sum(nil)
+
+	// todo 2. keys kdf
+	clientKey, serverKey := conn.TrafficKeys(version, secret, transcript)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogClientTraffic, hello.Nonce, clientKey)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogServerTraffic, hello.Nonce, serverKey)
+	sc := conn.New(c, version, clientKey, serverKey)
+	if !s.reissue(session, nowTs) {
+		return sc, nil
+	}
+
+	// todo 3. sendNewSessionTicket
+	ticketData, err := s.reissueTicket(hello, session, secret, transcript, nowTs)
+	if err != nil {
+		return nil, err
+	}
+	if err = sc.WriteHandshake(ticketData); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
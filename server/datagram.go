@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/ryanx-sir/simple-als/conn"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// HandshakeDatagram 在datagram连接上完成服务端握手，c的每次Read返回一个datagram
+// 客户端未收到响应会重传ClientHello，cookie重试不限次数，握手总时长由c的deadline限制
+// datagram握手始终要求绑定对端地址的cookie，使用当前配置的Retry密钥，不使用租户的Retry配置
+// c实现conn.ConnectionIDConn时协商connection ID，客户端切换地址后会话不中断
+func (s *server) HandshakeDatagram(c net.Conn) (*conn.Datagram, error) {
+	flights := conn.NewFlightConn(c, record.ProtocolAesGcm, s.rand)
+	for {
+		flight, err := flights.Read()
+		if err != nil {
+			return nil, err
+		}
+		nowTs := uint32(s.clock.Now().Unix())
+		hello, err := s.readHello(bytes.NewReader(flight), peerOf(c))
+		if err != nil {
+			return nil, err
+		}
+		ts, err := s.forClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		if hello.CipherSuite != util.DHE_SECP256R1_WITH_AES_GCM {
+			return nil, fmt.Errorf("cipher(%d) not support", hello.CipherSuite)
+		}
+		retry, err := s.datagramHello(hello, nowTs)
+		if err != nil {
+			return nil, err
+		}
+		if retry != nil {
+			if err = flights.Write(retry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// 底层连接支持按connection ID路由时，为客户端分配connection ID
+		if cc, ok := c.(conn.ConnectionIDConn); ok && hello.connectionID {
+			cids, err := cc.NewConnectionIDs(conn.ConnectionIDCount)
+			if err != nil {
+				return nil, err
+			}
+			hello.connectionIDs = bytes.Join(cids, nil)
+		}
+
+		done := ts.limiter.begin(nowTs)
+		resp, keys, err := ts.ecdheAesGcm(hello, nowTs)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		if err = flights.Write(resp); err != nil {
+			return nil, err
+		}
+		clientKey, serverKey := conn.TrafficKeys(record.ProtocolAesGcm, keys.secret, keys.transcript)
+		util.WriteKeyLog(ts.keyLogWriter, util.KeyLogClientTraffic, hello.Nonce, clientKey)
+		util.WriteKeyLog(ts.keyLogWriter, util.KeyLogServerTraffic, hello.Nonce, serverKey)
+		return flights.Established(clientKey, serverKey), nil
+	}
+}
+
+// DatagramRetry 无状态校验新对端的首个datagram，为其分配会话状态之前调用
+// 未携带cookie时返回HelloRetry datagram，cookie有效时返回nil，其他情况返回错误，都不做密钥计算
+func (s *server) DatagramRetry(peer *Peer, datagram []byte) ([]byte, error) {
+	rec, epoch, _, err := record.ParseDatagram(datagram)
+	if err != nil {
+		return nil, err
+	}
+	if epoch != 0 || rec.Type() != record.TypeHandshake {
+		return nil, util.ErrDataCorrupted
+	}
+	hello, err := s.readHello(bytes.NewReader(rec.GetData()), peer)
+	if err != nil {
+		return nil, err
+	}
+	if hello.CipherSuite != util.DHE_SECP256R1_WITH_AES_GCM {
+		return nil, fmt.Errorf("cipher(%d) not support", hello.CipherSuite)
+	}
+	retry, err := s.datagramHello(hello, uint32(s.clock.Now().Unix()))
+	if retry == nil || err != nil {
+		return nil, err
+	}
+	return record.New(record.TypeHandshake, record.ProtocolAesGcm, retry).MarshalDatagram(0, 0), nil
+}
+
+// datagramHello 未携带cookie时返回HelloRetry，否则校验cookie
+func (s *server) datagramHello(hello *ClientHelloInfo, nowTs uint32) ([]byte, error) {
+	cfg, err := s.datagramCookie()
+	if err != nil {
+		return nil, err
+	}
+	if hello.cookie == nil {
+		return helloRetry(cfg, s.rand, hello, nowTs)
+	}
+	return nil, verifyCookie(cfg, hello, nowTs)
+}
+
+// datagramCookie 配置了Retry时使用其密钥和有效期，否则首次使用时从Rand生成密钥
+func (s *server) datagramCookie() (*RetryConfig, error) {
+	s.datagramMu.Lock()
+	defer s.datagramMu.Unlock()
+	if s.datagramRetry != nil {
+		return s.datagramRetry, nil
+	}
+	if s.retry != nil {
+		s.datagramRetry = s.retry
+		return s.datagramRetry, nil
+	}
+	secret, err := util.RandomFrom(s.rand, 32)
+	if err != nil {
+		return nil, err
+	}
+	s.datagramRetry = &RetryConfig{Secret: secret}
+	return s.datagramRetry, nil
+}
@@ -2,56 +2,65 @@ package server
 
 import (
 	"crypto/ecdh"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/hex"
 	"github.com/ryanx-sir/simple-als/handshake"
 	"github.com/ryanx-sir/simple-als/record"
 	"github.com/ryanx-sir/simple-als/util"
 	"golang.org/x/crypto/pbkdf2"
-	"log"
 )
 
 /*
 ** 1-rtt EcdheAesGcm
-** cipherKey: client public key
+** hello.CipherKey: client public key
  */
-func (s *server) ecdheAesGcm(cipherKey []byte, nowTs uint32, clientHello []byte) (_ []byte, err error) {
+func (s *server) ecdheAesGcm(hello *ClientHelloInfo, nowTs uint32) (_ []byte, keys *handshakeKeys, err error) {
 	cure := ecdh.P256()
-	privateKey, err := cure.GenerateKey(rand.Reader) // 服务端临时生成公、私密钥对
+	privateKey, err := util.GenerateP256Key(s.rand) // 服务端临时生成公、私密钥对
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	hasher := sha256.New()
-	hasher.Write(clientHello)
+	hasher.Write(hello.raw)
 
 	var serverSeq uint32
 	// todo 1. sendServerHello
-	serverHello := handshake.NewMsg(nowTs, privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
+	serverHello, err := handshake.NewMsg(s.rand, nowTs, privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signature := s.signServerHello(hello, privateKey.PublicKey().Bytes()); signature != nil {
+		serverHello.SetExtension(handshake.ExtSignature, signature)
+	}
+	if hello.connectionIDs != nil {
+		serverHello.SetExtension(handshake.ExtConnectionID, []byte{1})
+	}
 	record1 := record.NewAesGcm(record.TypeHandshake, serverHello.Marshal(handshake.TypServerHello))
 	hasher.Write(record1.GetData())
 	serverSeq++
 
 	// todo 2. keys kdf
-	publicKey, err := cure.NewPublicKey(cipherKey)
+	publicKey, err := cure.NewPublicKey(hello.CipherKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	preSharedKey, _ := privateKey.ECDH(publicKey) // pre shared key
 	// 主密钥 = kdf(预主密钥+clientNonce+serverNonce)
 	masterKey := pbkdf2.Key(preSharedKey, append([]byte(util.MasterKdf),
 		hasher.Sum(nil)...), 1, 28, sha256.New) //[key:16+nonce:12]
-	log.Println("server", "masterKey", hex.EncodeToString(masterKey))
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogMaster, hello.Nonce, masterKey)
 	ticketKey := pbkdf2.Key(preSharedKey, append([]byte(util.TicketKdf),
 		hasher.Sum(nil)...), 1, 32, sha256.New) //[key:16+nonce:12]
-	log.Println("server", "ticketKey", hex.EncodeToString(ticketKey))
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogTicket, hello.Nonce, ticketKey)
 	keyPair := *(*[28]byte)(masterKey)
 
 	// todo 3. sendNewSessionTicket
-	newTicket := s.ticketEncoder.NewTicket(ticketKey, nowTs)
-	ticketData, err := newTicket.Data()
+	session, err := s.helloSession(hello, ticketKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	ticketData, err := s.newTicket(session, nowTs)
+	if err != nil {
+		return nil, nil, err
 	}
 	record2 := record.NewAesGcm(record.TypeHandshake, ticketData)
 	hasher.Write(record2.GetData())
@@ -60,6 +69,19 @@ func (s *server) ecdheAesGcm(cipherKey []byte, nowTs uint32, clientHello []byte)
 		return
 	}
 	serverSeq++
+	resp := append(record1.Marshal(), record2.Marshal()...)
+
+	// todo 4. sendConnectionIDs 加密下发，旁路观察者无法从握手中得知后续使用的connection ID
+	if hello.connectionIDs != nil {
+		record3 := record.NewAesGcm(record.TypeHandshake, append([]byte{handshake.TypConnectionIDs}, hello.connectionIDs...))
+		hasher.Write(record3.GetData())
+		if err = record3.AesGcmEncrypt(keyPair, serverSeq); err != nil {
+			return
+		}
+		serverSeq++
+		resp = append(resp, record3.Marshal()...)
+	}
 
-	return append(record1.Marshal(), record2.Marshal()...), nil
+	keys = &handshakeKeys{secret: preSharedKey, transcript: hasher.Sum(nil)}
+	return resp, keys, nil
 }
@@ -1,59 +1,64 @@
 package server
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/hex"
 	"github.com/ryanx-sir/simple-als/handshake"
 	"github.com/ryanx-sir/simple-als/record"
 	"github.com/ryanx-sir/simple-als/util"
 	"golang.org/x/crypto/curve25519"
-	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/pbkdf2"
-	"log"
 )
 
 /*
 ** 1-rtt ecdheNacl
-** cipherKey: client public key
+** hello.CipherKey: client public key
  */
-func (s *server) ecdheNacl(cipherKey []byte, nowTs uint32, clientHello []byte) (_ []byte, err error) {
-	if len(cipherKey) != 32 {
+func (s *server) ecdheNacl(hello *ClientHelloInfo, nowTs uint32) (_ []byte, err error) {
+	if len(hello.CipherKey) != 32 {
 		return nil, util.ErrDataCorrupted
 	}
-	publicKey, privateKey, err := box.GenerateKey(rand.Reader) // 服务端临时生成公、私密钥对
+	publicKey, privateKey, err := util.GenerateX25519Key(s.rand) // 服务端临时生成公、私密钥对
 	if err != nil {
 		return nil, err
 	}
 	hasher := sha256.New()
-	hasher.Write(clientHello)
+	hasher.Write(hello.raw)
 
 	// todo 1. sendServerHello
-	serverHello := handshake.NewMsg(nowTs, publicKey[:], util.DHE_X25519_WITH_XSALSA20_POLY1305)
+	serverHello, err := handshake.NewMsg(s.rand, nowTs, publicKey[:], util.DHE_X25519_WITH_XSALSA20_POLY1305)
+	if err != nil {
+		return nil, err
+	}
+	if signature := s.signServerHello(hello, publicKey[:]); signature != nil {
+		serverHello.SetExtension(handshake.ExtSignature, signature)
+	}
 	record1 := record.NewXsalsa20Poly1305(record.TypeHandshake, serverHello.Marshal(handshake.TypServerHello))
 	hasher.Write(record1.GetData())
 
 	// todo 2. keys kdf
-	preSharedKey, err := curve25519.X25519(privateKey[:], cipherKey) // pre shared key
+	preSharedKey, err := curve25519.X25519(privateKey[:], hello.CipherKey) // pre shared key
 	if err != nil {
 		return nil, err
 	}
 	masterKey := pbkdf2.Key(preSharedKey, append([]byte(util.MasterKdf),
 		hasher.Sum(nil)...), 1, 24, sha256.New)
-	log.Println("server", "masterKey", hex.EncodeToString(masterKey))
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogMaster, hello.Nonce, masterKey)
 
 	ticketKey := pbkdf2.Key(preSharedKey, append([]byte(util.TicketKdf),
 		hasher.Sum(nil)...), 1, 32, sha256.New)
-	log.Println("server", "ticketKey", hex.EncodeToString(ticketKey))
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogTicket, hello.Nonce, ticketKey)
 
 	// todo 3. sendNewSessionTicket
-	newTicket := s.ticketEncoder.NewTicket(ticketKey, nowTs)
-	ticketData, err := newTicket.Data()
+	session, err := s.helloSession(hello, ticketKey)
+	if err != nil {
+		return nil, err
+	}
+	ticketData, err := s.newTicket(session, nowTs)
 	if err != nil {
 		return nil, err
 	}
 	record2 := record.NewXsalsa20Poly1305(record.TypeHandshake, ticketData)
-	record2.NaclBox((*[24]byte)(masterKey), (*[32]byte)(cipherKey), privateKey)
+	record2.NaclBox((*[24]byte)(masterKey), (*[32]byte)(hello.CipherKey), privateKey)
 
 	return append(record1.Marshal(), record2.Marshal()...), nil
 }
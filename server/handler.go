@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/ryanx-sir/simple-als/ticket"
+)
+
+var ErrEarlyDataTooLarge = errors.New("early data too large")
+
+// Request 解密后的0-RTT应用数据
+type Request struct {
+	Data    []byte
+	Hello   *ClientHelloInfo
+	Session *ticket.Session // 本次恢复使用的票据信息
+}
+
+// Handler 处理应用数据，返回的数据加密为TypeApplicationData下发
+type Handler interface {
+	ServeALS(req *Request) ([]byte, error)
+}
+
+type HandlerFunc func(req *Request) ([]byte, error)
+
+func (f HandlerFunc) ServeALS(req *Request) ([]byte, error) {
+	return f(req)
+}
+
+// echoHandler 未配置Handler时的默认响应
+var echoHandler = HandlerFunc(func(req *Request) ([]byte, error) {
+	return append([]byte("hi, this is server response!\n "), req.Data...), nil
+})
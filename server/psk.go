@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+** 0-rtt psk，PSK_WITH_AES_GCM、PSK_WITH_XSALSA20_POLY1305共用，按套件选择record版本和密钥长度
+** hello.CipherKey: sessionTicket
+** 请求与响应都以加密的close_notify结束，clientSeq、serverSeq从1开始
+ */
+func (s *server) psk(hello *ClientHelloInfo, reader io.Reader, nowTs uint32) ([]byte, error) {
+	session, err := s.checkTicket(hello, nowTs)
+	if err != nil {
+		return nil, err
+	}
+	// PSK_DHE 客户端携带key share时，响应密钥混入新的ECDHE共享密钥
+	secret, keyShare, err := s.pskDhe(hello, session.TicketKey)
+	if err != nil {
+		return nil, err
+	}
+	ticketKey := session.TicketKey
+	version := record.SuiteVersion(hello.CipherSuite)
+	keySize := record.KeySize(version) // aes-gcm [key:16+nonce:12]，xsalsa20-poly1305 [key:32+nonce:24]
+	hasher := sha256.New()
+	hasher.Write(hello.raw)
+
+	//earlyKey = kdf(ticketKey+clientNonce)
+	earlyKey := pbkdf2.Key(ticketKey, append([]byte(util.EarlyKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogEarly, hello.Nonce, earlyKey)
+
+	// todo 1. readClientData 读取early data直到客户端加密的close_notify，被截断时返回io.ErrUnexpectedEOF
+	earlyData, err := io.ReadAll(io.LimitReader(record.NewReader(reader, earlyKey, 1), int64(s.maxEarlyData)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(earlyData) > s.maxEarlyData {
+		return nil, ErrEarlyDataTooLarge
+	}
+	resp, err := s.handler.ServeALS(&Request{Data: earlyData, Hello: hello, Session: session})
+	if err != nil {
+		return nil, err
+	}
+
+	// todo 2. sendServerHello
+	serverHello, err := handshake.NewMsg(s.rand, nowTs, hello.CipherKey, hello.CipherSuite)
+	if err != nil {
+		return nil, err
+	}
+	if keyShare != nil {
+		serverHello.SetExtension(handshake.ExtKeyShare, keyShare)
+	}
+	record2 := record.New(record.TypeHandshake, version, serverHello.Marshal(handshake.TypServerHello))
+	hasher.Write(record2.GetData())
+
+	// todo 3. sendServerData 响应以加密的close_notify结束，客户端据此检测截断
+	masterKey := pbkdf2.Key(secret, append([]byte(util.MasterKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogMaster, hello.Nonce, masterKey)
+
+	var out bytes.Buffer
+	w := record.NewWriter(&out, version, masterKey, 1)
+	w.Header = record2.Marshal()
+	if _, err = w.Write(resp); err != nil {
+		return nil, err
+	}
+
+	// todo 4. sendNewSessionTicket 单次使用或即将过期的票据，下发新票据
+	if s.reissue(session, nowTs) {
+		ticketData, err := s.reissueTicket(hello, session, secret, hasher.Sum(nil), nowTs)
+		if err != nil {
+			return nil, err
+		}
+		if err = w.WriteRecord(record.TypeHandshake, ticketData); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+var ErrCookieInvalid = errors.New("retry cookie invalid")
+
+// RetryConfig 无状态retry cookie
+// ECDHE握手负载超过阈值时，服务端先回复HelloRetry，客户端回传cookie后才生成密钥
+// MaxInflight、MaxPerSecond都为0时始终要求cookie
+// cookie绑定ClientHelloInfo.RemoteAddr的IP，传输层未提供对端地址时不绑定
+// 每个cookie只能使用一次，有效期内记录已使用的cookie，记录数达到maxUsedCookies时拒绝新的cookie
+type RetryConfig struct {
+	Secret       []byte        // HMAC密钥
+	MaxInflight  int64         // 进行中的ECDHE握手数阈值
+	MaxPerSecond int64         // 每秒ECDHE握手数阈值
+	CookieAlive  time.Duration // cookie有效期，默认30s
+}
+
+// maxUsedCookies 有效期内记录的已使用cookie上限
+const maxUsedCookies = 1 << 16
+
+// ecdheLimiter ECDHE握手负载统计
+type ecdheLimiter struct {
+	inflight atomic.Int64
+	window   atomic.Uint64 // [second:32][count:32]，当前秒内的ECDHE握手数
+
+	mu      sync.Mutex
+	cookies map[string]uint32 // 已使用的cookie -> 过期时间
+	sweepTs uint32            // 上次清理过期cookie的时间
+}
+
+// begin 登记一次ECDHE握手，返回结束回调
+func (l *ecdheLimiter) begin(nowTs uint32) func() {
+	l.inflight.Add(1)
+	for {
+		old := l.window.Load()
+		next := uint64(nowTs)<<32 | 1
+		if uint32(old>>32) == nowTs {
+			next = old + 1
+		}
+		if l.window.CompareAndSwap(old, next) {
+			break
+		}
+	}
+	return func() { l.inflight.Add(-1) }
+}
+
+// perSecond nowTs这一秒内的ECDHE握手数
+func (l *ecdheLimiter) perSecond(nowTs uint32) int64 {
+	window := l.window.Load()
+	if uint32(window>>32) != nowTs {
+		return 0
+	}
+	return int64(uint32(window))
+}
+
+func (l *ecdheLimiter) overload(cfg *RetryConfig, nowTs uint32) bool {
+	if cfg.MaxInflight == 0 && cfg.MaxPerSecond == 0 {
+		return true
+	}
+	if cfg.MaxInflight > 0 && l.inflight.Load() >= cfg.MaxInflight {
+		return true
+	}
+	return cfg.MaxPerSecond > 0 && l.perSecond(nowTs) >= cfg.MaxPerSecond
+}
+
+// useCookie 登记使用cookie，已使用过或记录数已满时返回false
+func (l *ecdheLimiter) useCookie(cookie []byte, expireTs, nowTs uint32) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cookies == nil {
+		l.cookies = make(map[string]uint32)
+	}
+	if l.sweepTs != nowTs {
+		l.sweepTs = nowTs
+		for k, ts := range l.cookies {
+			if ts < nowTs {
+				delete(l.cookies, k)
+			}
+		}
+	}
+	if _, ok := l.cookies[string(cookie)]; ok || len(l.cookies) >= maxUsedCookies {
+		return false
+	}
+	l.cookies[string(cookie)] = expireTs
+	return true
+}
+
+// checkRetry 负载过高且未携带cookie时返回HelloRetry
+// 携带有效cookie的握手不再检查负载，但仍由begin计入负载统计；cookie只能使用一次，重放返回ErrCookieInvalid
+func (s *server) checkRetry(hello *ClientHelloInfo, nowTs uint32) ([]byte, error) {
+	if s.retry == nil {
+		return nil, nil
+	}
+	if hello.cookie == nil {
+		if s.limiter.overload(s.retry, nowTs) {
+			return helloRetry(s.retry, s.rand, hello, nowTs)
+		}
+		return nil, nil
+	}
+	if err := verifyCookie(s.retry, hello, nowTs); err != nil {
+		return nil, err
+	}
+	if !s.limiter.useCookie(hello.cookie, cookieExpire(s.retry, hello.cookie), nowTs) {
+		return nil, ErrCookieInvalid
+	}
+	return nil, nil
+}
+
+// cookie = [ts:4][hmac(ts+clientNonce+suite+cipherKey+peerIP):16]
+// 绑定对端IP，伪造来源地址时收不到cookie；不绑定端口，HTTP重试可能使用新的TCP连接
+func makeCookie(cfg *RetryConfig, hello *ClientHelloInfo, nowTs uint32) []byte {
+	cookie := binary.BigEndian.AppendUint32(nil, nowTs)
+	mac := hmac.New(sha256.New, cfg.Secret)
+	mac.Write(cookie)
+	mac.Write(hello.Nonce)
+	mac.Write([]byte{hello.CipherSuite})
+	mac.Write(hello.CipherKey)
+	mac.Write([]byte(peerHost(hello.RemoteAddr)))
+	return mac.Sum(cookie)[:20]
+}
+
+// peerHost 对端地址去掉端口
+func peerHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func verifyCookie(cfg *RetryConfig, hello *ClientHelloInfo, nowTs uint32) error {
+	cookie := hello.cookie
+	if len(cookie) != 20 {
+		return ErrCookieInvalid
+	}
+	ts := binary.BigEndian.Uint32(cookie)
+	if ts > nowTs || cookieExpire(cfg, cookie) < nowTs {
+		return ErrCookieInvalid
+	}
+	if !hmac.Equal(cookie, makeCookie(cfg, hello, ts)) {
+		return ErrCookieInvalid
+	}
+	return nil
+}
+
+// cookieExpire cookie的过期时间，cookie长度已校验
+func cookieExpire(cfg *RetryConfig, cookie []byte) uint32 {
+	alive := uint32(cfg.CookieAlive.Seconds())
+	if alive == 0 {
+		alive = 30
+	}
+	return binary.BigEndian.Uint32(cookie) + alive
+}
+
+// helloRetry 回复携带cookie的HelloRetry，不做任何密钥计算
+func helloRetry(cfg *RetryConfig, rand io.Reader, hello *ClientHelloInfo, nowTs uint32) ([]byte, error) {
+	retry, err := handshake.NewMsg(rand, nowTs, nil, hello.CipherSuite)
+	if err != nil {
+		return nil, err
+	}
+	retry.SetExtension(handshake.ExtCookie, makeCookie(cfg, hello, nowTs))
+	if hello.CipherSuite == util.DHE_X25519_WITH_XSALSA20_POLY1305 {
+		return record.NewXsalsa20Poly1305(record.TypeHandshake, retry.Marshal(handshake.TypHelloRetry)).Marshal(), nil
+	}
+	return record.NewAesGcm(record.TypeHandshake, retry.Marshal(handshake.TypHelloRetry)).Marshal(), nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+func Test_RetryCookie(t *testing.T) {
+	s, err := NewServer(&Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		Retry:         &RetryConfig{Secret: []byte("cookie secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	clientHello, err := handshake.NewMsg(rand.Reader, uint32(time.Now().Unix()), privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle := func() ([]byte, error) {
+		hello := record.NewAesGcm(record.TypeHandshake, clientHello.Marshal(handshake.TypClientHello))
+		resp, err := s.Handle(bytes.NewReader(hello.Marshal()))
+		if err != nil {
+			return nil, err
+		}
+		record1, err := record.ReadNew(bytes.NewReader(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record1.GetData(), nil
+	}
+
+	data, err := handle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := handshake.Unmarshal(data, handshake.TypHelloRetry)
+	if err != nil {
+		t.Fatal("want HelloRetry", err)
+	}
+	cookie := retry.Extension(handshake.ExtCookie)
+
+	clientHello.SetExtension(handshake.ExtCookie, cookie)
+	if data, err = handle(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = handshake.Unmarshal(data, handshake.TypServerHello); err != nil {
+		t.Fatal("want ServerHello", err)
+	}
+	// cookie只能使用一次
+	if _, err = handle(); !errors.Is(err, ErrCookieInvalid) {
+		t.Fatal("want ErrCookieInvalid on replay", err)
+	}
+
+	cookie[len(cookie)-1] ^= 1
+	if _, err = handle(); !errors.Is(err, ErrCookieInvalid) {
+		t.Fatal("want ErrCookieInvalid", err)
+	}
+}
+
+func Test_LimiterPerSecond(t *testing.T) {
+	l := &ecdheLimiter{}
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(nowTs uint32) {
+			defer wg.Done()
+			l.begin(nowTs)()
+		}(1700000000 + uint32(i/32))
+	}
+	wg.Wait()
+	if n := l.perSecond(1700000001); n > 32 {
+		t.Fatal("count exceeds handshakes in second", n)
+	}
+	l.begin(1700000002)()
+	if n := l.perSecond(1700000002); n != 1 {
+		t.Fatal("want count reset on new second", n)
+	}
+}
+
+func Test_RetryCookiePeer(t *testing.T) {
+	s, err := NewServer(&Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		Retry:         &RetryConfig{Secret: []byte("cookie secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	clientHello, err := handshake.NewMsg(rand.Reader, uint32(time.Now().Unix()), privateKey.PublicKey().Bytes(), util.DHE_SECP256R1_WITH_AES_GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := func(remoteAddr string) ([]byte, error) {
+		hello := record.NewAesGcm(record.TypeHandshake, clientHello.Marshal(handshake.TypClientHello))
+		resp, err := s.HandleFrom(&Peer{RemoteAddr: remoteAddr}, bytes.NewReader(hello.Marshal()))
+		if err != nil {
+			return nil, err
+		}
+		record1, err := record.ReadNew(bytes.NewReader(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record1.GetData(), nil
+	}
+
+	data, err := handle("10.0.0.1:1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := handshake.Unmarshal(data, handshake.TypHelloRetry)
+	if err != nil {
+		t.Fatal("want HelloRetry", err)
+	}
+	clientHello.SetExtension(handshake.ExtCookie, retry.Extension(handshake.ExtCookie))
+
+	// 其他地址回传同一cookie无效
+	if _, err = handle("10.0.0.2:1000"); !errors.Is(err, ErrCookieInvalid) {
+		t.Fatal("want ErrCookieInvalid", err)
+	}
+	// 同一IP换端口仍有效
+	if data, err = handle("10.0.0.1:2000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = handshake.Unmarshal(data, handshake.TypServerHello); err != nil {
+		t.Fatal("want ServerHello", err)
+	}
+}
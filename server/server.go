@@ -1,6 +1,10 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"github.com/ryanx-sir/simple-als/handshake"
@@ -8,43 +12,346 @@ import (
 	"github.com/ryanx-sir/simple-als/ticket"
 	"github.com/ryanx-sir/simple-als/util"
 	"io"
+	"log"
+	"net"
+	"sync"
 	"time"
 )
 
+// Config 服务端配置，零值字段使用默认值，NewServer时校验
+type Config struct {
+	TicketEncoder *ticket.Encoder
+
+	// KeyLogWriter 调试用，按NSS key log格式输出会话密钥以便解密抓包
+	// 会泄露会话密钥，生产环境不要设置
+	KeyLogWriter io.Writer
+
+	// Retry 不为nil时启用无状态retry cookie，保护ECDHE握手
+	Retry *RetryConfig
+
+	// Revocation PSK握手时查询票据是否被吊销
+	Revocation ticket.RevocationStore
+	// SingleUseTicket 票据只能使用一次，每次PSK握手下发新票据
+	// Revocation为nil时使用进程内的ticket.MemoryRevocation
+	SingleUseTicket bool
+
+	// Handler 处理PSK early data，为nil时回显
+	Handler Handler
+	// StreamHandler 处理流式PSK请求，为nil时读取全部请求后交给Handler
+	StreamHandler StreamHandler
+
+	// SessionStore 不为nil时会话保存在服务端，票据只是随机ID，代替TicketEncoder
+	SessionStore ticket.SessionStore
+	// TicketLifetime 票据有效期，默认使用TicketEncoder的配置，使用SessionStore时默认24小时
+	TicketLifetime time.Duration
+	// TicketRenewBefore 票据剩余有效期小于该值时，PSK响应中下发新票据，为0时不下发
+	TicketRenewBefore time.Duration
+	// RequirePskDhe PSK请求必须携带key share，响应和新票据具有前向安全性
+	RequirePskDhe bool
+
+	// CipherSuites 允许的密码套件，为空时不限制
+	CipherSuites []uint8
+	// IdentityKey 服务端身份密钥，不为nil时对ECDHE ServerHello签名，客户端可据此认证服务端
+	IdentityKey ed25519.PrivateKey
+
+	// GetConfigForClient 多租户，按ClientHello(ServerName)选择租户配置
+	// 返回nil时使用当前配置，当前配置没有TicketEncoder或SessionStore时握手返回ErrNoTenantConfig
+	// 服务端实例按Config.Tenant和TenantVersion缓存，每次返回新的*Config也复用，修改租户配置时须增加TenantVersion，
+	// 重建后ECDHE负载统计和SingleUseTicket的消费记录沿用同一Tenant之前的实例
+	GetConfigForClient func(hello *ClientHelloInfo) (*Config, error)
+
+	Clock    util.Clock    // 默认系统时间
+	Rand     io.Reader     // 默认crypto/rand
+	Logger   *log.Logger   // 握手失败日志，不输出密钥，默认不输出
+	Observer util.Observer // 握手事件回调
+
+	// MaxEarlyData 单次PSK请求early data上限，默认1MB
+	MaxEarlyData int
+
+	// Tenant 租户名，写入票据，PSK恢复时必须与签发租户一致
+	Tenant string
+	// TenantVersion 租户配置版本，GetConfigForClient返回的版本变化时按新配置重建服务端实例
+	TenantVersion uint64
+	// TicketAppData ECDHE握手签发票据时写入的应用数据，PSK恢复时见Request.Session.AppData
+	TicketAppData func(hello *ClientHelloInfo) []byte
+	// TicketIdentity ECDHE握手签发票据时写入的客户端身份，用于按身份吊销，应取自已认证的来源
+	// 为nil时使用ClientHello中客户端声明的Identity，未经认证，按身份吊销只是参考
+	TicketIdentity func(hello *ClientHelloInfo) (string, error)
+}
+
+// DefaultMaxEarlyData 默认early data上限
+const DefaultMaxEarlyData = 1 << 20
+
+// ClientHelloInfo 解析后的ClientHello
+type ClientHelloInfo struct {
+	CipherSuite uint8
+	Nonce       []byte
+	CipherKey   []byte
+	Identity    string // 客户端声明的身份，未经认证，见Config.TicketIdentity
+	ServerName  string // 租户标识
+	RemoteAddr  string // 对端地址，传输层未提供时为空
+
+	ctx      context.Context
+	raw      []byte // ClientHello record数据，计入transcript hash
+	cookie   []byte
+	stream   bool   // 流式PSK请求
+	keyShare []byte // PSK_DHE客户端临时公钥
+
+	connectionID  bool   // 客户端支持datagram connection ID
+	connectionIDs []byte // 分配给客户端的connection ID，在ServerHello之后加密下发
+}
+
 type server struct {
-	reader        io.Reader
 	ticketEncoder *ticket.Encoder
+	keyLogWriter  io.Writer
+	retry         *RetryConfig
+	limiter       *ecdheLimiter
+	revocation    ticket.RevocationStore
+	singleUse     bool
+	handler       Handler
+	streamHandler StreamHandler
+	sessionStore  ticket.SessionStore
+	ticketAlive   uint32
+	renewBefore   uint32
+	requirePskDhe bool
+	cipherSuites  []uint8
+	identityKey   ed25519.PrivateKey
+	clock         util.Clock
+	rand          io.Reader
+	logger        *log.Logger
+	observer      util.Observer
+	maxEarlyData  int
+	tenantName    string
+	ticketAppData func(hello *ClientHelloInfo) []byte
+	identity      func(hello *ClientHelloInfo) (string, error)
+
+	getConfigForClient func(hello *ClientHelloInfo) (*Config, error)
+	tenantMu           sync.Mutex
+	tenants            map[string]*tenant // 按Config.Tenant
+
+	datagramMu    sync.Mutex
+	datagramRetry *RetryConfig // datagram握手的cookie配置，首次使用时创建
+}
+
+func NewServer(config *Config) (*server, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	s := &server{
+		ticketEncoder: config.TicketEncoder,
+		keyLogWriter:  config.KeyLogWriter,
+		retry:         config.Retry,
+		limiter:       &ecdheLimiter{},
+		revocation:    config.Revocation,
+		singleUse:     config.SingleUseTicket,
+		handler:       config.Handler,
+		streamHandler: config.StreamHandler,
+		sessionStore:  config.SessionStore,
+		ticketAlive:   uint32(config.TicketLifetime.Seconds()),
+		renewBefore:   uint32(config.TicketRenewBefore.Seconds()),
+		requirePskDhe: config.RequirePskDhe,
+		cipherSuites:  config.CipherSuites,
+		identityKey:   config.IdentityKey,
+		clock:         config.Clock,
+		rand:          config.Rand,
+		logger:        config.Logger,
+		observer:      config.Observer,
+		maxEarlyData:  config.MaxEarlyData,
+		tenantName:    config.Tenant,
+		ticketAppData: config.TicketAppData,
+		identity:      config.TicketIdentity,
+
+		getConfigForClient: config.GetConfigForClient,
+	}
+	if s.handler == nil {
+		s.handler = echoHandler
+	}
+	if s.ticketAlive == 0 && s.sessionStore == nil && s.ticketEncoder != nil {
+		s.ticketAlive = uint32(s.ticketEncoder.Lifetime().Seconds())
+	}
+	if s.ticketAlive == 0 {
+		s.ticketAlive = 24 * 3600
+	}
+	if s.clock == nil {
+		s.clock = util.SystemClock
+	}
+	if s.rand == nil {
+		s.rand = rand.Reader
+	}
+	if s.maxEarlyData == 0 {
+		s.maxEarlyData = DefaultMaxEarlyData
+	}
+	if s.singleUse && s.revocation == nil && s.sessionStore == nil {
+		revocation := ticket.NewMemoryRevocation()
+		revocation.Clock = s.clock
+		s.revocation = revocation
+	}
+	return s, nil
+}
+
+func (c *Config) validate() error {
+	if c == nil {
+		return errors.New("server config is nil")
+	}
+	if c.TicketEncoder == nil && c.SessionStore == nil && c.GetConfigForClient == nil {
+		return errors.New("server config: TicketEncoder or SessionStore required")
+	}
+	for _, suite := range c.CipherSuites {
+		if !util.SupportedSuite(suite) {
+			return fmt.Errorf("server config: cipher(%d) not support", suite)
+		}
+	}
+	if c.IdentityKey != nil && len(c.IdentityKey) != ed25519.PrivateKeySize {
+		return errors.New("server config: invalid IdentityKey")
+	}
+	if c.Retry != nil && len(c.Retry.Secret) == 0 {
+		return errors.New("server config: Retry.Secret required")
+	}
+	if c.TicketLifetime < 0 || c.TicketRenewBefore < 0 || c.MaxEarlyData < 0 {
+		return errors.New("server config: negative TicketLifetime, TicketRenewBefore or MaxEarlyData")
+	}
+	return nil
+}
+
+// Context 传输层请求的context，如HTTP承载时的http.Request.Context，未提供时为context.Background
+func (h *ClientHelloInfo) Context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+	return h.ctx
+}
+
+// Peer 传输层提供的请求来源
+type Peer struct {
+	Context    context.Context // 请求取消时handler可提前结束
+	RemoteAddr string          // 对端地址，启用Retry时cookie绑定对端IP
+}
+
+// peerOf 流式连接和datagram连接的对端
+func peerOf(c net.Conn) *Peer {
+	if addr := c.RemoteAddr(); addr != nil {
+		return &Peer{RemoteAddr: addr.String()}
+	}
+	return nil
+}
+
+// Handle 票据被拒绝时返回告警record，客户端可重新握手
+func (s *server) Handle(reader io.Reader) ([]byte, error) {
+	return s.serve(nil, reader, nil)
+}
+
+// HandleFrom 同Handle，peer为请求来源，可为nil
+func (s *server) HandleFrom(peer *Peer, reader io.Reader) ([]byte, error) {
+	return s.serve(peer, reader, nil)
+}
+
+// HandleStream 流式PSK请求的响应边处理边写入w，其他请求处理完后整体写入
+func (s *server) HandleStream(reader io.Reader, w io.Writer) error {
+	return s.HandleStreamFrom(nil, reader, w)
+}
+
+// HandleStreamFrom 同HandleStream，peer为请求来源，可为nil
+func (s *server) HandleStreamFrom(peer *Peer, reader io.Reader, w io.Writer) error {
+	resp, err := s.serve(peer, reader, w)
+	if err != nil {
+		return err
+	}
+	if len(resp) > 0 {
+		_, err = w.Write(resp)
+	}
+	return err
 }
 
-func NewServer(ticketEncoder *ticket.Encoder) *server {
-	return &server{ticketEncoder: ticketEncoder}
+func (s *server) serve(peer *Peer, reader io.Reader, w io.Writer) ([]byte, error) {
+	start := s.clock.Now()
+	event := util.HandshakeEvent{}
+	resp, err := s.handle(peer, reader, w, &event)
+	event.Duration = s.clock.Now().Sub(start)
+	event.Err = err
+	s.observe(event)
+	if errors.Is(err, ErrTicketRejected) {
+		return record.NewAlert(record.SuiteVersion(event.CipherSuite), record.AlertTicketRejected).Marshal(), nil
+	}
+	return resp, err
 }
 
-// Handle
-func (s *server) Handle(reader io.Reader) (_ []byte, err error) {
+func (s *server) handle(peer *Peer, reader io.Reader, w io.Writer, event *util.HandshakeEvent) (_ []byte, err error) {
 	if reader == nil {
 		return nil, errors.New("reader is nil")
 	}
-	nowTs := time.Now().Unix()
+	nowTs := uint32(s.clock.Now().Unix())
+	hello, err := s.readHello(reader, peer)
+	if err != nil {
+		return nil, err
+	}
+	event.CipherSuite, event.ServerName = hello.CipherSuite, hello.ServerName
+	if s, err = s.forClient(hello); err != nil {
+		return nil, err
+	}
+	switch hello.CipherSuite {
+	case util.DHE_SECP256R1_WITH_AES_GCM, util.DHE_X25519_WITH_XSALSA20_POLY1305: // 1-RTT ECDHE
+		if retry, err := s.checkRetry(hello, nowTs); retry != nil || err != nil {
+			event.Retry = retry != nil
+			return retry, err
+		}
+		defer s.limiter.begin(nowTs)()
+		if hello.CipherSuite == util.DHE_X25519_WITH_XSALSA20_POLY1305 {
+			return s.ecdheNacl(hello, nowTs)
+		}
+		resp, _, err := s.ecdheAesGcm(hello, nowTs)
+		return resp, err
+	case util.PSK_WITH_AES_GCM, util.PSK_WITH_XSALSA20_POLY1305: // 0-RTT PSK
+		event.Resumed = true
+		if hello.stream {
+			if w == nil {
+				var buf bytes.Buffer
+				err = s.pskStream(hello, reader, &buf, nowTs)
+				return buf.Bytes(), err
+			}
+			return nil, s.pskStream(hello, reader, w, nowTs)
+		}
+		return s.psk(hello, reader, nowTs)
+	}
+	return nil, fmt.Errorf("cipher(%d) not support", hello.CipherSuite)
+}
+
+// observe 记录握手事件，失败时输出日志
+func (s *server) observe(event util.HandshakeEvent) {
+	if event.Err != nil && s.logger != nil {
+		s.logger.Printf("wdals: handshake cipher(%d) server name %q failed: %v", event.CipherSuite, event.ServerName, event.Err)
+	}
+	if s.observer != nil {
+		s.observer.ObserveHandshake(event)
+	}
+}
+
+func (s *server) readHello(reader io.Reader, peer *Peer) (*ClientHelloInfo, error) {
 	helloRecord, err := record.ReadNew(reader)
 	if err != nil {
 		return nil, err
 	}
-	helloData := helloRecord.GetData()
+	if helloRecord.Type() != record.TypeHandshake {
+		return nil, util.ErrDataCorrupted
+	}
 	clientHello, err := handshake.Unmarshal(helloRecord.GetData(), handshake.TypClientHello)
 	if err != nil {
 		return nil, err
 	}
-	s.reader = reader
-	switch clientHello.CipherSuite() {
-	case util.DHE_SECP256R1_WITH_AES_GCM: // 1-RTT ECDHE
-		return s.ecdheAesGcm(clientHello.CipherKey(), uint32(nowTs), helloData)
-	case util.DHE_X25519_WITH_XSALSA20_POLY1305: // 1-RTT ECDHE
-		return s.ecdheNacl(clientHello.CipherKey(), uint32(nowTs), helloData)
-	case util.PSK_WITH_AES_GCM: // 0-RTT PSK
-		return s.pskAesGcm(clientHello.CipherKey(), uint32(nowTs), helloData)
-	case util.PSK_WITH_XSALSA20_POLY1305:
-		// todo
-	}
-	return nil, fmt.Errorf("cipher(%d) not support", clientHello.CipherSuite())
+	hello := &ClientHelloInfo{
+		CipherSuite: clientHello.CipherSuite(),
+		Nonce:       clientHello.Nonce(),
+		CipherKey:   clientHello.CipherKey(),
+		Identity:    string(clientHello.Extension(handshake.ExtIdentity)),
+		ServerName:  string(clientHello.Extension(handshake.ExtServerName)),
+		raw:         helloRecord.GetData(),
+		cookie:      clientHello.Extension(handshake.ExtCookie),
+		stream:      clientHello.Extension(handshake.ExtStream) != nil,
+		keyShare:    clientHello.Extension(handshake.ExtKeyShare),
+
+		connectionID: clientHello.Extension(handshake.ExtConnectionID) != nil,
+	}
+	if peer != nil {
+		hello.ctx, hello.RemoteAddr = peer.Context, peer.RemoteAddr
+	}
+	return hello, nil
 }
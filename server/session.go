@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var ErrTicketBinding = errors.New("ticket bound to another cipher suite or tenant")
+
+// ErrPskDheRequired 配置了RequirePskDhe但客户端未携带key share
+var ErrPskDheRequired = errors.New("psk_dhe key share required")
+
+// ErrTicketRejected PSK票据校验失败，early data未被处理
+var ErrTicketRejected = errors.New("ticket rejected")
+
+// newTicket 签发票据，返回NewSessionTicket数据 [expireTs:4][ticket]
+// 配置了SessionStore时票据为会话ID，否则为TicketEncoder加密的会话状态
+func (s *server) newTicket(session *ticket.Session, nowTs uint32) ([]byte, error) {
+	session.ExpireTs = nowTs + s.ticketAlive
+	session.Tenant = s.tenantName
+	if session.IssueTs == 0 {
+		session.IssueTs = nowTs
+	}
+	if s.sessionStore == nil {
+		t, err := s.ticketEncoder.NewTicket(s.rand, session)
+		if err != nil {
+			return nil, err
+		}
+		return t.Data()
+	}
+	id, err := util.RandomFrom(s.rand, len(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	copy(session.ID[:], id)
+	if err := s.sessionStore.Put(session); err != nil {
+		return nil, err
+	}
+	data := binary.BigEndian.AppendUint32(nil, session.ExpireTs)
+	return append(data, session.ID[:]...), nil
+}
+
+// checkTicket 解析票据并校验有效期、密码套件和租户绑定、吊销状态，失败时返回ErrTicketRejected
+func (s *server) checkTicket(hello *ClientHelloInfo, nowTs uint32) (*ticket.Session, error) {
+	session, err := s.loadTicket(hello, nowTs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTicketRejected, err)
+	}
+	return session, nil
+}
+
+func (s *server) loadTicket(hello *ClientHelloInfo, nowTs uint32) (session *ticket.Session, err error) {
+	data := hello.CipherKey
+	if s.sessionStore != nil {
+		var id ticket.ID
+		if len(data) != len(id) {
+			return nil, ticket.ErrTicketIllegal
+		}
+		copy(id[:], data)
+		if s.singleUse {
+			session, err = s.sessionStore.Take(id) // 并发使用同一票据时只有一个成功
+		} else {
+			session, err = s.sessionStore.Get(id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if session, err = s.ticketEncoder.Decode(data); err != nil {
+		return nil, err
+	}
+	if session.ExpireTs < nowTs {
+		return nil, errors.New("session key expire")
+	}
+	if util.ResumptionSuite(session.CipherSuite) != hello.CipherSuite || session.Tenant != s.tenantName {
+		return nil, ErrTicketBinding
+	}
+	if s.revocation != nil {
+		if err = s.revocation.Check(session, s.singleUse); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// helloSession ECDHE握手签发票据的会话信息，身份已被吊销时不签发
+func (s *server) helloSession(hello *ClientHelloInfo, ticketKey []byte) (*ticket.Session, error) {
+	session := &ticket.Session{
+		CipherSuite: hello.CipherSuite,
+		Identity:    hello.Identity,
+		TicketKey:   ticketKey,
+	}
+	if s.identity != nil {
+		identity, err := s.identity(hello)
+		if err != nil {
+			return nil, err
+		}
+		session.Identity = identity
+	}
+	if s.revocation != nil && session.Identity != "" {
+		if err := s.revocation.Check(session, false); err != nil {
+			return nil, err
+		}
+	}
+	if s.ticketAppData != nil {
+		session.AppData = s.ticketAppData(hello)
+	}
+	return session, nil
+}
+
+// reissue PSK响应是否下发新票据：单次使用票据，或剩余有效期小于TicketRenewBefore
+func (s *server) reissue(session *ticket.Session, nowTs uint32) bool {
+	return s.singleUse || (s.renewBefore > 0 && session.ExpireTs < nowTs+s.renewBefore)
+}
+
+// reissueTicket PSK响应中的NewSessionTicket: [TypNewSessionTicket][expireTs:4][ticket]
+// 新票据密钥 = kdf(secret+transcript)，沿用原会话的密码套件、签发时间、身份和应用数据
+func (s *server) reissueTicket(hello *ClientHelloInfo, session *ticket.Session, secret, transcript []byte, nowTs uint32) ([]byte, error) {
+	ticketKey := pbkdf2.Key(secret, append([]byte(util.TicketKdf), transcript...), 1, 32, sha256.New)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogTicket, hello.Nonce, ticketKey)
+	ticketData, err := s.newTicket(&ticket.Session{
+		CipherSuite: session.CipherSuite,
+		IssueTs:     session.IssueTs,
+		Identity:    session.Identity,
+		AppData:     session.AppData,
+		TicketKey:   ticketKey,
+	}, nowTs)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{handshake.TypNewSessionTicket}, ticketData...), nil
+}
+
+// pskDhe 返回派生响应密钥的secret和服务端key share
+// 客户端未携带key share时secret为票据密钥，配置了RequirePskDhe时拒绝
+func (s *server) pskDhe(hello *ClientHelloInfo, ticketKey []byte) (secret, keyShare []byte, err error) {
+	if hello.keyShare == nil {
+		if s.requirePskDhe {
+			return nil, nil, ErrPskDheRequired
+		}
+		return ticketKey, nil, nil
+	}
+	share, err := util.NewKeyShare(s.rand, hello.CipherSuite)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := share.Shared(hello.keyShare)
+	if err != nil {
+		return nil, nil, err
+	}
+	return util.PskDheSecret(ticketKey, shared), share.Public, nil
+}
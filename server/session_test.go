@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+func Test_TicketBinding(t *testing.T) {
+	encoder := ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}})
+	newServer := func(tenant string) *server {
+		s, err := NewServer(&Config{
+			TicketEncoder: encoder,
+			Tenant:        tenant,
+			TicketAppData: func(hello *ClientHelloInfo) []byte { return []byte("uid=" + hello.Identity) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	a, b := newServer("a"), newServer("b")
+	nowTs := uint32(time.Now().Unix())
+	hello := &ClientHelloInfo{CipherSuite: util.DHE_SECP256R1_WITH_AES_GCM, Identity: "device-1"}
+	helloSession, err := a.helloSession(hello, []byte("ticket key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := a.newTicket(helloSession, nowTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resume := &ClientHelloInfo{CipherSuite: util.PSK_WITH_AES_GCM, CipherKey: data[4:]}
+	session, err := a.checkTicket(resume, nowTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Tenant != "a" || session.IssueTs != nowTs || session.CipherSuite != util.DHE_SECP256R1_WITH_AES_GCM ||
+		!bytes.Equal(session.AppData, []byte("uid=device-1")) {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if _, err = b.checkTicket(resume, nowTs); !errors.Is(err, ErrTicketBinding) {
+		t.Fatal("want tenant binding err", err)
+	}
+	resume.CipherSuite = util.PSK_WITH_XSALSA20_POLY1305
+	if _, err = a.checkTicket(resume, nowTs); !errors.Is(err, ErrTicketBinding) {
+		t.Fatal("want suite binding err", err)
+	}
+}
+
+func Test_TicketIdentity(t *testing.T) {
+	revocation := ticket.NewMemoryRevocation()
+	s, err := NewServer(&Config{
+		TicketEncoder: ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}}),
+		Revocation:    revocation,
+		// 身份取自已认证的来源，不使用客户端声明的Identity
+		TicketIdentity: func(hello *ClientHelloInfo) (string, error) { return "device-1", nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nowTs := uint32(time.Now().Unix())
+	hello := &ClientHelloInfo{CipherSuite: util.DHE_SECP256R1_WITH_AES_GCM, Identity: "device-2"}
+	session, err := s.helloSession(hello, []byte("ticket key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Identity != "device-1" {
+		t.Fatal("unexpected identity", session.Identity)
+	}
+	data, err := s.newTicket(session, nowTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 吊销后PSK恢复和ECDHE签发都被拒绝
+	revocation.RevokeIdentity("device-1")
+	resume := &ClientHelloInfo{CipherSuite: util.PSK_WITH_AES_GCM, CipherKey: data[4:]}
+	if _, err = s.checkTicket(resume, nowTs); !errors.Is(err, ticket.ErrTicketRevoked) {
+		t.Fatal("want revoked on psk", err)
+	}
+	if _, err = s.helloSession(hello, []byte("ticket key")); !errors.Is(err, ticket.ErrTicketRevoked) {
+		t.Fatal("want revoked on ecdhe", err)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/ryanx-sir/simple-als/handshake"
+	"github.com/ryanx-sir/simple-als/record"
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// StreamRequest 流式PSK请求
+// Body读到客户端加密的close_notify为止，请求被截断时返回io.ErrUnexpectedEOF
+type StreamRequest struct {
+	Body    io.Reader
+	Hello   *ClientHelloInfo
+	Session *ticket.Session
+}
+
+// StreamHandler 流式处理early data，写入w的数据分块加密下发，返回后发送close_notify
+// 返回错误时不发送close_notify，客户端读取响应得到io.ErrUnexpectedEOF
+// HTTP/1.1下应先读完Body再写响应
+type StreamHandler interface {
+	ServeStream(w io.Writer, req *StreamRequest) error
+}
+
+type StreamHandlerFunc func(w io.Writer, req *StreamRequest) error
+
+func (f StreamHandlerFunc) ServeStream(w io.Writer, req *StreamRequest) error {
+	return f(w, req)
+}
+
+// bufferedStream 未配置StreamHandler时，读取全部请求交给Handler
+func (s *server) bufferedStream(w io.Writer, req *StreamRequest) error {
+	data, err := io.ReadAll(io.LimitReader(req.Body, int64(s.maxEarlyData)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > s.maxEarlyData {
+		return ErrEarlyDataTooLarge
+	}
+	resp, err := s.handler.ServeALS(&Request{Data: data, Hello: req.Hello, Session: req.Session})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(resp)
+	return err
+}
+
+/*
+** 0-rtt pskStream
+** hello.CipherKey: sessionTicket
+** 请求与响应都以加密的close_notify结束，ServerHello在首次写入时发送
+ */
+func (s *server) pskStream(hello *ClientHelloInfo, reader io.Reader, w io.Writer, nowTs uint32) error {
+	session, err := s.checkTicket(hello, nowTs)
+	if err != nil {
+		return err
+	}
+	// PSK_DHE 客户端携带key share时，响应密钥混入新的ECDHE共享密钥
+	secret, keyShare, err := s.pskDhe(hello, session.TicketKey)
+	if err != nil {
+		return err
+	}
+	ticketKey := session.TicketKey
+	version := record.SuiteVersion(hello.CipherSuite)
+	keySize := record.KeySize(version)
+
+	hasher := sha256.New()
+	hasher.Write(hello.raw)
+
+	//earlyKey = kdf(ticketKey+clientNonce)
+	earlyKey := pbkdf2.Key(ticketKey, append([]byte(util.EarlyKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogEarly, hello.Nonce, earlyKey)
+
+	// todo 1. serverHello
+	serverHello, err := handshake.NewMsg(s.rand, nowTs, hello.CipherKey, hello.CipherSuite)
+	if err != nil {
+		return err
+	}
+	if keyShare != nil {
+		serverHello.SetExtension(handshake.ExtKeyShare, keyShare)
+	}
+	serverHello.SetExtension(handshake.ExtStream, []byte{1})
+	record1 := record.New(record.TypeHandshake, version, serverHello.Marshal(handshake.TypServerHello))
+	hasher.Write(record1.GetData())
+
+	masterKey := pbkdf2.Key(secret, append([]byte(util.MasterKdf),
+		hasher.Sum(nil)...), 1, keySize, sha256.New)
+	util.WriteKeyLog(s.keyLogWriter, util.KeyLogMaster, hello.Nonce, masterKey)
+
+	// todo 2. 交给handler边读边写，clientSeq、serverSeq从1开始
+	body := record.NewReader(reader, earlyKey, 1)
+	out := record.NewWriter(w, version, masterKey, 1)
+	out.Header = record1.Marshal()
+	req := &StreamRequest{Body: body, Hello: hello, Session: session}
+	if s.streamHandler != nil {
+		err = s.streamHandler.ServeStream(out, req)
+	} else {
+		err = s.bufferedStream(out, req)
+	}
+	if err != nil {
+		return err
+	}
+
+	// todo 3. sendNewSessionTicket 单次使用或即将过期的票据，下发新票据
+	if s.reissue(session, nowTs) {
+		ticketData, err := s.reissueTicket(hello, session, secret, hasher.Sum(nil), nowTs)
+		if err != nil {
+			return err
+		}
+		if err = out.WriteRecord(record.TypeHandshake, ticketData); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
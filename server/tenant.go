@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+// ErrNoTenantConfig GetConfigForClient返回nil，而当前配置没有TicketEncoder或SessionStore
+var ErrNoTenantConfig = errors.New("no tenant config for client hello")
+
+// forClient 选择租户并校验密码套件
+func (s *server) forClient(hello *ClientHelloInfo) (*server, error) {
+	ts := s
+	if s.getConfigForClient != nil {
+		config, err := s.getConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			if ts, err = s.tenant(config); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ts.ticketEncoder == nil && ts.sessionStore == nil {
+		return nil, ErrNoTenantConfig // 只配置了GetConfigForClient，且未选择租户
+	}
+	if len(ts.cipherSuites) == 0 {
+		return ts, nil
+	}
+	for _, suite := range ts.cipherSuites {
+		if suite == hello.CipherSuite {
+			return ts, nil
+		}
+	}
+	return nil, fmt.Errorf("cipher(%d) not allowed", hello.CipherSuite)
+}
+
+// tenant 租户配置及其服务端实例
+type tenant struct {
+	config *Config
+	server *server
+}
+
+// tenant 按Config.Tenant和TenantVersion复用服务端实例，不比较*Config，
+// 版本变化时重建并沿用负载统计和票据消费记录
+func (s *server) tenant(config *Config) (*server, error) {
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	prev, ok := s.tenants[config.Tenant]
+	if ok && prev.config.TenantVersion == config.TenantVersion {
+		return prev.server, nil
+	}
+	if s.tenants == nil {
+		s.tenants = make(map[string]*tenant)
+	}
+	ts, err := NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	ts.getConfigForClient = nil
+	if ok {
+		ts.limiter = prev.server.limiter
+		if config.Revocation == nil && prev.config.Revocation == nil && prev.server.revocation != nil {
+			ts.revocation = prev.server.revocation // NewServer为SingleUseTicket创建的MemoryRevocation
+		}
+	}
+	s.tenants[config.Tenant] = &tenant{config: config, server: ts}
+	return ts, nil
+}
+
+// signServerHello 配置了IdentityKey时，对ClientHello和服务端临时公钥签名
+func (s *server) signServerHello(hello *ClientHelloInfo, serverKey []byte) []byte {
+	if s.identityKey == nil {
+		return nil
+	}
+	return ed25519.Sign(s.identityKey, util.SignedContent(hello.raw, serverKey))
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/ticket"
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+func Test_TenantCache(t *testing.T) {
+	encoder := ticket.NewEncoder(time.Hour, map[uint16]ticket.SecretKey{1: {1}})
+	var version uint64
+	s, err := NewServer(&Config{
+		TicketEncoder: encoder,
+		// 每次返回新的*Config，版本不变时复用服务端实例
+		GetConfigForClient: func(hello *ClientHelloInfo) (*Config, error) {
+			return &Config{TicketEncoder: encoder, Tenant: hello.ServerName, TenantVersion: version}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := &ClientHelloInfo{CipherSuite: util.DHE_SECP256R1_WITH_AES_GCM, ServerName: "a"}
+	first, err := s.forClient(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		ts, err := s.forClient(hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts != first {
+			t.Fatal("server rebuilt for unchanged tenant version")
+		}
+	}
+	version++
+	ts, err := s.forClient(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts == first {
+		t.Fatal("server not rebuilt for new tenant version")
+	}
+	if ts.limiter != first.limiter {
+		t.Fatal("limiter not shared across versions of the same tenant")
+	}
+	if len(s.tenants) != 1 {
+		t.Fatal("unexpected tenant cache size", len(s.tenants))
+	}
+}
+
+func Test_TenantNilConfig(t *testing.T) {
+	s, err := NewServer(&Config{
+		GetConfigForClient: func(hello *ClientHelloInfo) (*Config, error) { return nil, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := &ClientHelloInfo{CipherSuite: util.DHE_SECP256R1_WITH_AES_GCM}
+	if _, err = s.forClient(hello); !errors.Is(err, ErrNoTenantConfig) {
+		t.Fatal("want ErrNoTenantConfig", err)
+	}
+}
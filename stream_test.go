@@ -0,0 +1,76 @@
+package wdals
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RequestStream(t *testing.T) {
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder:   newTestEncoder(),
+		SingleUseTicket: true,
+		StreamHandler: StreamHandlerFunc(func(w io.Writer, req *StreamRequest) error {
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			if string(body) == "truncate" {
+				w.Write([]byte("partial"))
+				return errors.New("handler failed")
+			}
+			// 响应为请求的3倍，分多次写入
+			for i := 0; i < 3; i++ {
+				if _, err = w.Write(body); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(NewHTTPHandler(srv))
+	defer hs.Close()
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64<<10) // 1MB
+	for _, suite := range []uint8{DHE_SECP256R1_WITH_AES_GCM, DHE_X25519_WITH_XSALSA20_POLY1305} {
+		c := newTestClient(t, hs.URL, &ClientConfig{CipherSuites: []uint8{suite}})
+		for i := 0; i < 2; i++ { // 单次使用票据，第二次使用流式响应中下发的新票据
+			resp, err := c.RequestStream(bytes.NewReader(big))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(resp)
+			resp.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, bytes.Repeat(big, 3)) {
+				t.Fatal("unexpected response", len(data))
+			}
+		}
+
+		resp, err := c.RequestStream(bytes.NewReader([]byte("truncate")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp)
+		resp.Close()
+		if !errors.Is(err, io.ErrUnexpectedEOF) || string(data) != "partial" {
+			t.Fatal("want truncation detected", string(data), err)
+		}
+
+		// 流式请求体同样受MaxHTTPBody限制
+		if resp, err = c.RequestStream(bytes.NewReader(make([]byte, MaxHTTPBody+1))); err == nil {
+			_, err = io.ReadAll(resp)
+			resp.Close()
+		}
+		if err == nil {
+			t.Fatal("want request body limit")
+		}
+	}
+}
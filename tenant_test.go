@@ -0,0 +1,100 @@
+package wdals
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ryanx-sir/simple-als/client"
+)
+
+func Test_Tenants(t *testing.T) {
+	publicKey, identityKey, _ := ed25519.GenerateKey(nil)
+	tenants := map[string]*ServerConfig{}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		tenants[name] = &ServerConfig{
+			TicketEncoder: newTestEncoder(),
+			Tenant:        name,
+			IdentityKey:   identityKey,
+			Handler: HandlerFunc(func(req *Request) ([]byte, error) {
+				return []byte(name + ":" + string(req.Data)), nil
+			}),
+		}
+	}
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder: newTestEncoder(),
+		GetConfigForClient: func(hello *ClientHelloInfo) (*ServerConfig, error) {
+			if config, ok := tenants[hello.ServerName]; ok {
+				return config, nil
+			}
+			return nil, errors.New("unknown tenant")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(NewHTTPHandler(srv))
+	defer hs.Close()
+
+	for _, name := range []string{"a", "b"} {
+		c := newTestClient(t, hs.URL, &ClientConfig{ServerName: name, ServerKey: publicKey})
+		if err := c.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Request([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != name+":ping" {
+			t.Fatal("unexpected response", string(resp))
+		}
+	}
+
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	c := newTestClient(t, hs.URL, &ClientConfig{ServerName: "a", ServerKey: otherKey})
+	if err := c.Handshake(); !errors.Is(err, client.ErrServerSignature) {
+		t.Fatal("want ErrServerSignature", err)
+	}
+	if err := newTestClient(t, hs.URL, &ClientConfig{ServerName: "c"}).Handshake(); err == nil {
+		t.Fatal("want unknown tenant error")
+	}
+}
+
+func Test_TenantsFreshConfig(t *testing.T) {
+	encoder := newTestEncoder()
+	var calls atomic.Int32
+	srv, err := NewServer(&ServerConfig{
+		TicketEncoder: newTestEncoder(),
+		// 每次返回新的*Config，单次使用票据的消费记录仍按Tenant共享
+		GetConfigForClient: func(hello *ClientHelloInfo) (*ServerConfig, error) {
+			return &ServerConfig{
+				TicketEncoder:   encoder,
+				Tenant:          hello.ServerName,
+				SingleUseTicket: true,
+				Handler: HandlerFunc(func(req *Request) ([]byte, error) {
+					calls.Add(1)
+					return req.Data, nil
+				}),
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &recordTransport{ClientTransport: NewMemoryTransport(srv)}
+	c := newTestClient(t, "", &ClientConfig{ServerName: "a", Transport: transport})
+	for i := 0; i < 3; i++ {
+		if _, err = c.Request([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = transport.ClientTransport.Exchange(transport.last, false); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Fatal("replayed single-use ticket handled", calls.Load())
+	}
+}
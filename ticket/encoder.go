@@ -2,59 +2,112 @@ package ticket
 
 import (
 	"errors"
+	"github.com/ryanx-sir/simple-als/util"
+	"io"
+	"sync"
 	"time"
 )
 
 var ErrTicketVersion = errors.New("ticket version err")
 var ErrTicketDecode = errors.New("ticket decode err")
+var ErrTicketRetired = errors.New("ticket key retired")
 
 type SecretKey = [32]byte
 
+// Encoder 票据加解密，支持运行时轮换密钥，并发安全
 type Encoder struct {
-	version     uint16
-	secretKey   map[uint16]SecretKey
+	mu          sync.RWMutex
+	version     uint16 // 当前加密使用的版本
+	secretKey   map[uint16]*secretState
+	retired     map[uint16]struct{}
 	ticketAlive uint32
 }
 
+type secretState struct {
+	key         SecretKey
+	decryptOnly bool
+	addTs       int64 // 加入顺序，轮换时先淘汰最早的
+}
+
+// NewEncoder 最大版本作为加密版本，其余版本可用于解密
 func NewEncoder(ticketAlive time.Duration, secretKey map[uint16]SecretKey) (e *Encoder) {
 	if len(secretKey) == 0 {
 		panic("ticket secret nil")
 	}
-	var max uint16
-	for version := range secretKey {
-		if version > max {
-			max = version
-		}
-	}
-	return &Encoder{
-		version:     max,
-		secretKey:   secretKey,
+	e = &Encoder{
+		secretKey:   make(map[uint16]*secretState, len(secretKey)),
+		retired:     make(map[uint16]struct{}),
 		ticketAlive: uint32(ticketAlive.Seconds()),
 	}
+	for version, key := range secretKey {
+		if version > e.version {
+			e.version = version
+		}
+		e.secretKey[version] = &secretState{key: key, addTs: int64(version)}
+	}
+	return e
+}
+
+// Session 票据解密后得到的会话信息
+type Session struct {
+	ID          ID
+	CipherSuite uint8  // 建立会话时的密码套件，只能用对应的PSK套件恢复
+	IssueTs     uint32 // 首次签发时间，重新签发的票据保持不变
+	ExpireTs    uint32
+	Identity    string // 客户端身份
+	Tenant      string // 签发票据的租户
+	AppData     []byte // 应用自定义数据，服务端不解析
+	TicketKey   []byte
+}
+
+// Lifetime 票据有效期
+func (e *Encoder) Lifetime() time.Duration {
+	return time.Duration(e.ticketAlive) * time.Second
 }
 
-func (e Encoder) NewTicket(ticketKey []byte, now uint32) *sessionTicket {
-	return &sessionTicket{
-		version:   e.version,
-		expireTs:  now + e.ticketAlive,
-		ticketKey: ticketKey,
-		secret:    e.secretKey[e.version],
+// NewTicket 用session生成票据，票据ID和加密nonce从rand读取，不使用只解密的版本
+func (e *Encoder) NewTicket(rand io.Reader, session *Session) (*sessionTicket, error) {
+	e.mu.RLock()
+	version, ok := e.encryptVersion()
+	if !ok {
+		e.mu.RUnlock()
+		return nil, ErrKeyNotFound
+	}
+	t := &sessionTicket{
+		version: version,
+		session: *session,
+		secret:  e.secretKey[version].key,
+		rand:    rand,
 	}
+	e.mu.RUnlock()
+	id, err := util.RandomFrom(rand, len(t.session.ID))
+	if err != nil {
+		return nil, err
+	}
+	copy(t.session.ID[:], id)
+	return t, nil
 }
 
-func (e Encoder) Decode(data []byte) (ticketKey []byte, expireTs uint32, err error) {
+// Decode 已退役版本加密的票据返回ErrTicketRetired
+func (e *Encoder) Decode(data []byte) (*Session, error) {
 	ticket, err := matchTicketVer(data)
 	if err != nil {
-		return nil, 0, errors.Join(ErrTicketVersion, err)
+		return nil, errors.Join(ErrTicketVersion, err)
+	}
+	e.mu.RLock()
+	state, verOk := e.secretKey[ticket.version]
+	_, retired := e.retired[ticket.version]
+	e.mu.RUnlock()
+	if retired {
+		return nil, ErrTicketRetired
 	}
-	var verOk bool
-	ticket.secret, verOk = e.secretKey[ticket.version]
 	if !verOk {
-		return nil, 0, ErrTicketVersion
+		return nil, ErrTicketVersion
 	}
+	ticket.secret = state.key
 	err = ticket.decrypt(data)
 	if err != nil {
-		return nil, 0, errors.Join(ErrTicketDecode, err)
+		return nil, errors.Join(ErrTicketDecode, err)
 	}
-	return ticket.ticketKey, ticket.expireTs, nil
+	return &ticket.session, nil
 }
@@ -0,0 +1,51 @@
+package ticket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func Test_TicketFields(t *testing.T) {
+	e := NewEncoder(time.Hour, map[uint16]SecretKey{1: {1}})
+	want := &Session{
+		CipherSuite: 0xc9,
+		IssueTs:     100,
+		ExpireTs:    200,
+		Identity:    "device-1",
+		Tenant:      "a.example.com",
+		AppData:     []byte("user=42"),
+		TicketKey:   []byte("ticket key"),
+	}
+	st, err := e.NewTicket(rand.Reader, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := st.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Decode(data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != st.ID() || got.CipherSuite != want.CipherSuite || got.IssueTs != want.IssueTs ||
+		got.ExpireTs != want.ExpireTs || got.Identity != want.Identity || got.Tenant != want.Tenant ||
+		!bytes.Equal(got.AppData, want.AppData) || !bytes.Equal(got.TicketKey, want.TicketKey) {
+		t.Fatalf("unexpected session %+v", got)
+	}
+}
+
+// sealTicket 签发票据，返回[expireTs:4][ticket]
+func sealTicket(t *testing.T, e *Encoder, session *Session) []byte {
+	st, err := e.NewTicket(rand.Reader, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := st.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,226 @@
+package ticket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrKeyringInvalid = errors.New("ticket keyring invalid")
+
+// Keyring 票据密钥集合，Active为加密版本，其余版本只用于解密
+type Keyring struct {
+	Active uint16
+	Keys   map[uint16]SecretKey
+}
+
+// KeyProvider 票据密钥来源，多个服务实例共享同一份密钥
+type KeyProvider interface {
+	Keyring() (*Keyring, error)
+}
+
+// keyringFile 密钥文件格式，密钥为base64编码的32字节
+// {"active":2,"keys":{"1":"...","2":"..."}}
+type keyringFile struct {
+	Active uint16            `json:"active"`
+	Keys   map[uint16]string `json:"keys"`
+}
+
+// ParseKeyring 解析JSON格式的密钥文件
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Join(ErrKeyringInvalid, err)
+	}
+	ring := &Keyring{Active: f.Active, Keys: make(map[uint16]SecretKey, len(f.Keys))}
+	for version, encoded := range f.Keys {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != len(SecretKey{}) {
+			return nil, fmt.Errorf("%w: key version %d", ErrKeyringInvalid, version)
+		}
+		ring.Keys[version] = SecretKey(raw)
+	}
+	if _, ok := ring.Keys[ring.Active]; !ok {
+		return nil, fmt.Errorf("%w: active version %d missing", ErrKeyringInvalid, ring.Active)
+	}
+	return ring, nil
+}
+
+// NewEncoderFromProvider 用provider当前的密钥创建Encoder，密钥为空或缺少加密版本时返回ErrKeyringInvalid
+func NewEncoderFromProvider(ticketAlive time.Duration, p KeyProvider) (*Encoder, error) {
+	ring, err := p.Keyring()
+	if err != nil {
+		return nil, err
+	}
+	if ring == nil || len(ring.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrKeyringInvalid)
+	}
+	if _, ok := ring.Keys[ring.Active]; !ok {
+		return nil, fmt.Errorf("%w: active version %d missing", ErrKeyringInvalid, ring.Active)
+	}
+	e := NewEncoder(ticketAlive, ring.Keys)
+	if err = e.Sync(ring); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Sync 使Encoder与ring一致：加入新版本、切换加密版本、退役ring中已删除的版本
+// 先校验整个ring再在锁内一次应用，校验失败时Encoder不变；ring中已退役的非加密版本跳过
+func (e *Encoder) Sync(ring *Keyring) error {
+	if ring == nil {
+		return fmt.Errorf("%w: nil keyring", ErrKeyringInvalid)
+	}
+	if _, ok := ring.Keys[ring.Active]; !ok {
+		return ErrKeyNotFound
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// todo 1. 校验：加密版本不能已退役，同一版本密钥不能变化
+	if _, ok := e.retired[ring.Active]; ok {
+		return fmt.Errorf("%w: active version %d retired", ErrKeyExists, ring.Active)
+	}
+	for version, key := range ring.Keys {
+		if state, ok := e.secretKey[version]; ok && state.key != key {
+			return fmt.Errorf("%w: version %d changed", ErrKeyExists, version)
+		}
+	}
+	// todo 2. 加入新版本
+	for version, key := range ring.Keys {
+		if _, ok := e.secretKey[version]; ok {
+			continue
+		}
+		if _, ok := e.retired[version]; ok {
+			continue
+		}
+		_ = e.addKey(version, key)
+	}
+	// todo 3. 切换加密版本
+	if e.version != ring.Active {
+		e.secretKey[e.version].decryptOnly = true
+		e.version = ring.Active
+	}
+	e.secretKey[ring.Active].decryptOnly = false
+	// todo 4. 退役已删除的版本
+	for version := range e.secretKey {
+		if _, ok := ring.Keys[version]; !ok {
+			_ = e.retire(version)
+		}
+	}
+	return nil
+}
+
+// Watch 每隔interval从provider同步一次密钥，返回的stop用于停止
+func (e *Encoder) Watch(p KeyProvider, interval time.Duration, onErr func(error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ring, err := p.Keyring()
+				if err == nil {
+					err = e.Sync(ring)
+				}
+				if err != nil && onErr != nil {
+					onErr(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// FileKeyProvider 从本地密钥文件读取，文件修改时间变化才重新解析
+type FileKeyProvider struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	ring    *Keyring
+}
+
+func (p *FileKeyProvider) Keyring() (*Keyring, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if p.ring != nil && info.ModTime().Equal(p.modTime) {
+		return p.ring, nil
+	}
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := ParseKeyring(data)
+	if err != nil {
+		return nil, err
+	}
+	p.ring, p.modTime = ring, info.ModTime()
+	return ring, nil
+}
+
+// HTTPKeyProvider 从密钥服务拉取，内容未变化(ETag)时复用上次结果
+type HTTPKeyProvider struct {
+	URL    string
+	Client *http.Client // 默认http.DefaultClient
+
+	mu   sync.Mutex
+	etag string
+	ring *Keyring
+}
+
+func (p *HTTPKeyProvider) Keyring() (*Keyring, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, err := http.NewRequest(http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.ring != nil && p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if p.ring != nil {
+			return p.ring, nil
+		}
+	case http.StatusOK:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		ring, err := ParseKeyring(data)
+		if err != nil {
+			return nil, err
+		}
+		p.ring, p.etag = ring, resp.Header.Get("ETag")
+		return ring, nil
+	}
+	return nil, fmt.Errorf("keyring fetch: %s", resp.Status)
+}
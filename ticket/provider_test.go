@@ -0,0 +1,115 @@
+package ticket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_FileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	write := func(data string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+	now := time.Now()
+	write(`{"active":1,"keys":{"1":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`, now)
+	p := &FileKeyProvider{Path: path}
+	e, err := NewEncoderFromProvider(time.Hour, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := sealTicket(t, e, &Session{TicketKey: []byte("key")})
+
+	write(`{"active":2,"keys":{"2":"AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`, now.Add(time.Second))
+	ring, err := p.Keyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Sync(ring); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := e.Versions(); active != 2 {
+		t.Fatal("unexpected active", active)
+	}
+	if _, err = e.Decode(data[4:]); !errors.Is(err, ErrTicketRetired) {
+		t.Fatal("want retired", err)
+	}
+}
+
+// ringProvider 返回固定的Keyring
+type ringProvider struct {
+	ring *Keyring
+}
+
+func (p ringProvider) Keyring() (*Keyring, error) {
+	return p.ring, nil
+}
+
+func Test_EncoderFromEmptyProvider(t *testing.T) {
+	for _, ring := range []*Keyring{nil, {Active: 1}, {Active: 2, Keys: map[uint16]SecretKey{1: {1}}}} {
+		if _, err := NewEncoderFromProvider(time.Hour, ringProvider{ring}); !errors.Is(err, ErrKeyringInvalid) {
+			t.Fatal("want ErrKeyringInvalid", err)
+		}
+	}
+}
+
+func Test_HTTPKeyProvider(t *testing.T) {
+	var hits, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"active":1,"keys":{"1":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`))
+	}))
+	defer srv.Close()
+
+	p := &HTTPKeyProvider{URL: srv.URL}
+	for i := 0; i < 2; i++ {
+		ring, err := p.Keyring()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ring.Active != 1 || ring.Keys[1] != (SecretKey{1}) {
+			t.Fatal("unexpected keyring", ring)
+		}
+	}
+	if hits != 2 || notModified != 1 {
+		t.Fatal("unexpected requests", hits, notModified)
+	}
+}
+
+func Test_EncoderSync(t *testing.T) {
+	e := NewEncoder(time.Hour, map[uint16]SecretKey{1: {1}})
+	if err := e.Sync(&Keyring{Active: 2, Keys: map[uint16]SecretKey{2: {2}}}); err != nil {
+		t.Fatal(err)
+	}
+	// 已退役的版本重新出现时跳过，不影响后续轮换
+	if err := e.Sync(&Keyring{Active: 3, Keys: map[uint16]SecretKey{1: {1}, 2: {2}, 3: {3}}}); err != nil {
+		t.Fatal(err)
+	}
+	if active, versions := e.Versions(); active != 3 || len(versions) != 2 {
+		t.Fatal("unexpected versions", active, versions)
+	}
+	// 校验失败时不做任何修改
+	err := e.Sync(&Keyring{Active: 4, Keys: map[uint16]SecretKey{2: {9}, 4: {4}}})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatal("want changed key err", err)
+	}
+	if active, versions := e.Versions(); active != 3 || len(versions) != 2 {
+		t.Fatal("partial sync applied", active, versions)
+	}
+	if err = e.Sync(&Keyring{Active: 1, Keys: map[uint16]SecretKey{1: {1}}}); !errors.Is(err, ErrKeyExists) {
+		t.Fatal("retired version activated", err)
+	}
+}
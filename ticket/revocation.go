@@ -0,0 +1,100 @@
+package ticket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+var ErrTicketRevoked = errors.New("ticket revoked")
+var ErrTicketReused = errors.New("ticket already used")
+
+// RevocationStore 票据吊销与消费记录，服务端PSK握手时查询
+type RevocationStore interface {
+	// Check 校验票据是否被吊销；consume为true时登记消费，再次出现返回ErrTicketReused
+	// 校验与登记需原子完成，并发使用同一票据时只有一个成功
+	Check(session *Session, consume bool) error
+}
+
+// MemoryRevocation 进程内的RevocationStore，记录到票据过期为止
+type MemoryRevocation struct {
+	Clock util.Clock // 默认系统时间
+
+	mu         sync.Mutex
+	revoked    map[ID]uint32 // 票据ID -> 过期时间
+	used       map[ID]uint32
+	identities map[string]struct{}
+	pruneTs    uint32
+}
+
+func NewMemoryRevocation() *MemoryRevocation {
+	return &MemoryRevocation{
+		revoked:    make(map[ID]uint32),
+		used:       make(map[ID]uint32),
+		identities: make(map[string]struct{}),
+	}
+}
+
+// RevokeID 吊销单个票据，expireTs之后记录自动清除
+func (m *MemoryRevocation) RevokeID(id ID, expireTs uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[id] = expireTs
+}
+
+// RevokeIdentity 吊销某客户端身份的全部票据，身份来自服务端的TicketIdentity，未配置时由客户端声明
+func (m *MemoryRevocation) RevokeIdentity(identity string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identities[identity] = struct{}{}
+}
+
+// RestoreIdentity 取消对客户端身份的吊销
+func (m *MemoryRevocation) RestoreIdentity(identity string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.identities, identity)
+}
+
+func (m *MemoryRevocation) Check(session *Session, consume bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session.Identity != "" {
+		if _, ok := m.identities[session.Identity]; ok {
+			return ErrTicketRevoked
+		}
+	}
+	if _, ok := m.revoked[session.ID]; ok {
+		return ErrTicketRevoked
+	}
+	if !consume {
+		return nil
+	}
+	if _, ok := m.used[session.ID]; ok {
+		return ErrTicketReused
+	}
+	m.used[session.ID] = session.ExpireTs
+	now := time.Now()
+	if m.Clock != nil {
+		now = m.Clock.Now()
+	}
+	m.prune(uint32(now.Unix()))
+	return nil
+}
+
+// prune 每分钟最多一次，清除已过期的记录
+func (m *MemoryRevocation) prune(nowTs uint32) {
+	if nowTs < m.pruneTs+60 {
+		return
+	}
+	m.pruneTs = nowTs
+	for _, ids := range []map[ID]uint32{m.revoked, m.used} {
+		for id, expireTs := range ids {
+			if expireTs < nowTs {
+				delete(ids, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ticket
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Revocation(t *testing.T) {
+	e := NewEncoder(time.Hour, map[uint16]SecretKey{1: {1}})
+	nowTs := uint32(time.Now().Unix())
+	data := sealTicket(t, e, &Session{Identity: "device-1", ExpireTs: nowTs + 3600, TicketKey: []byte("ticket key")})
+	session, err := e.Decode(data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Identity != "device-1" || string(session.TicketKey) != "ticket key" {
+		t.Fatal("session mismatch", session)
+	}
+
+	store := NewMemoryRevocation()
+	if err = store.Check(session, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Check(session, true); !errors.Is(err, ErrTicketReused) {
+		t.Fatal("want ErrTicketReused", err)
+	}
+	store.RevokeIdentity("device-1")
+	if err = store.Check(session, false); !errors.Is(err, ErrTicketRevoked) {
+		t.Fatal("want ErrTicketRevoked", err)
+	}
+	store.RestoreIdentity("device-1")
+	store.RevokeID(session.ID, session.ExpireTs)
+	if err = store.Check(session, false); !errors.Is(err, ErrTicketRevoked) {
+		t.Fatal("want ErrTicketRevoked", err)
+	}
+}
+
+func Test_RevocationConsumeConcurrent(t *testing.T) {
+	store := NewMemoryRevocation()
+	session := &Session{ID: ID{1}, ExpireTs: uint32(time.Now().Unix()) + 60}
+	var wg sync.WaitGroup
+	var consumed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.Check(session, true) == nil {
+				consumed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed.Load() != 1 {
+		t.Fatal("want exactly one consume", consumed.Load())
+	}
+}
@@ -0,0 +1,193 @@
+package ticket
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+var ErrKeyExists = errors.New("ticket key version exists")
+var ErrKeyNotFound = errors.New("ticket key version not found")
+var ErrKeyActive = errors.New("ticket key version is active")
+
+// AddKey 加入新密钥，只用于解密，Promote后才用于加密
+// 已退役的版本不能再次加入，避免旧票据重新生效
+func (e *Encoder) AddKey(version uint16, key SecretKey) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addKey(version, key)
+}
+
+func (e *Encoder) addKey(version uint16, key SecretKey) error {
+	if _, ok := e.secretKey[version]; ok {
+		return ErrKeyExists
+	}
+	if _, ok := e.retired[version]; ok {
+		return ErrKeyExists
+	}
+	var last int64
+	for _, state := range e.secretKey {
+		if state.addTs > last {
+			last = state.addTs
+		}
+	}
+	e.secretKey[version] = &secretState{key: key, decryptOnly: true, addTs: last + 1}
+	return nil
+}
+
+// Promote 设置加密使用的版本，原版本继续可解密
+func (e *Encoder) Promote(version uint16) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state, ok := e.secretKey[version]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	state.decryptOnly = false
+	e.version = version
+	return nil
+}
+
+// DecryptOnly 标记版本只用于解密，为当前加密版本时改用最近加入的可加密版本，没有时返回ErrKeyActive
+func (e *Encoder) DecryptOnly(version uint16) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state, ok := e.secretKey[version]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	state.decryptOnly = true
+	if version != e.version {
+		return nil
+	}
+	next, ok := e.encryptVersion()
+	if !ok {
+		state.decryptOnly = false
+		return ErrKeyActive
+	}
+	e.version = next
+	return nil
+}
+
+// encryptVersion 加密使用的版本，跳过只解密的版本：当前版本可加密时使用当前版本，否则取最近加入的可加密版本
+func (e *Encoder) encryptVersion() (uint16, bool) {
+	if state, ok := e.secretKey[e.version]; ok && !state.decryptOnly {
+		return e.version, true
+	}
+	var version uint16
+	var last int64
+	found := false
+	for v, state := range e.secretKey {
+		if !state.decryptOnly && (!found || state.addTs > last) {
+			version, last, found = v, state.addTs, true
+		}
+	}
+	return version, found
+}
+
+// Retire 删除版本密钥，此后该版本票据解密返回ErrTicketRetired
+func (e *Encoder) Retire(version uint16) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.retire(version)
+}
+
+func (e *Encoder) retire(version uint16) error {
+	if _, ok := e.secretKey[version]; !ok {
+		return ErrKeyNotFound
+	}
+	if version == e.version {
+		return ErrKeyActive
+	}
+	delete(e.secretKey, version)
+	e.retired[version] = struct{}{}
+	return nil
+}
+
+// Versions 当前加密版本和全部可解密版本
+func (e *Encoder) Versions() (active uint16, versions []uint16) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for version := range e.secretKey {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return e.version, versions
+}
+
+// Rotate 从rand生成新密钥并设为加密版本，旧版本只用于解密，最多保留keep个旧版本
+// 旧版本需至少保留一个票据有效期，否则未过期的票据会被拒绝
+func (e *Encoder) Rotate(rand io.Reader, keep int) (uint16, error) {
+	var key SecretKey
+	random, err := util.RandomFrom(rand, len(key))
+	if err != nil {
+		return 0, err
+	}
+	copy(key[:], random)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// todo 1. 新版本号取未使用过的下一个
+	version := e.version
+	for {
+		version++
+		if version == e.version {
+			return 0, errors.New("ticket key version exhausted")
+		}
+		_, used := e.secretKey[version]
+		_, retired := e.retired[version]
+		if !used && !retired {
+			break
+		}
+	}
+	if err := e.addKey(version, key); err != nil {
+		return 0, err
+	}
+	// todo 2. 切换加密版本
+	e.secretKey[e.version].decryptOnly = true
+	e.secretKey[version].decryptOnly = false
+	e.version = version
+
+	// todo 3. 按加入顺序淘汰多余的旧版本
+	var old []uint16
+	for v := range e.secretKey {
+		if v != version {
+			old = append(old, v)
+		}
+	}
+	sort.Slice(old, func(i, j int) bool { return e.secretKey[old[i]].addTs < e.secretKey[old[j]].addTs })
+	for len(old) > keep {
+		_ = e.retire(old[0])
+		old = old[1:]
+	}
+	return version, nil
+}
+
+// AutoRotate 每隔every调用一次Rotate，返回的stop用于停止
+func (e *Encoder) AutoRotate(rand io.Reader, every time.Duration, keep int, onErr func(error)) (stop func()) {
+	ticker := time.NewTicker(every)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := e.Rotate(rand, keep); err != nil && onErr != nil {
+					onErr(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ticket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_EncoderRotate(t *testing.T) {
+	e := NewEncoder(time.Hour, map[uint16]SecretKey{1: {1}})
+	seal := func() []byte {
+		return sealTicket(t, e, &Session{TicketKey: []byte("key")})[4:]
+	}
+	t1 := seal()
+	if _, err := e.Rotate(rand.Reader, 1); err != nil {
+		t.Fatal(err)
+	}
+	t2 := seal()
+	if _, err := e.Decode(t1); err != nil {
+		t.Fatal("old version should decrypt", err)
+	}
+	if _, err := e.Rotate(rand.Reader, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Decode(t1); !errors.Is(err, ErrTicketRetired) {
+		t.Fatal("want retired", err)
+	}
+	if _, err := e.Decode(t2); err != nil {
+		t.Fatal(err)
+	}
+	active, versions := e.Versions()
+	if active != 3 || len(versions) != 2 {
+		t.Fatal("unexpected versions", active, versions)
+	}
+	if err := e.Retire(active); !errors.Is(err, ErrKeyActive) {
+		t.Fatal("want active err", err)
+	}
+	if err := e.AddKey(1, SecretKey{}); !errors.Is(err, ErrKeyExists) {
+		t.Fatal("retired version reused", err)
+	}
+}
+
+func Test_EncoderDecryptOnly(t *testing.T) {
+	e := NewEncoder(time.Hour, map[uint16]SecretKey{1: {1}, 2: {2}})
+	// 票据nonce末2字节为加密版本
+	sealVersion := func() uint16 {
+		data := sealTicket(t, e, &Session{TicketKey: []byte("key")})[4:]
+		return binary.BigEndian.Uint16(data[22:24])
+	}
+	if v := sealVersion(); v != 2 {
+		t.Fatal("want version 2", v)
+	}
+	t2 := sealTicket(t, e, &Session{TicketKey: []byte("key")})[4:]
+
+	if err := e.DecryptOnly(2); err != nil {
+		t.Fatal(err)
+	}
+	if v := sealVersion(); v != 1 {
+		t.Fatal("decrypt-only version used for sealing", v)
+	}
+	if _, err := e.Decode(t2); err != nil {
+		t.Fatal("decrypt-only version should decrypt", err)
+	}
+	// 没有其他可加密的版本
+	if err := e.DecryptOnly(1); !errors.Is(err, ErrKeyActive) {
+		t.Fatal("want ErrKeyActive", err)
+	}
+	if v := sealVersion(); v != 1 {
+		t.Fatal("want version 1", v)
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"errors"
 	"github.com/ryanx-sir/simple-als/util"
 	"golang.org/x/crypto/nacl/secretbox"
+	"io"
 )
 
 var ErrTicketIllegal = errors.New("ticket illegal")
 
+type ID = [16]byte
+
+// ticketFormat 票据明文格式版本，与加密密钥版本无关
+const ticketFormat uint8 = 1
+
 // sessionTicket
 type sessionTicket struct {
-	version   uint16 // 加解密版本
-	expireTs  uint32 // 过期时间
-	ticketKey []byte
-	secret    SecretKey
+	version uint16 // 加解密版本
+	session Session
+	secret  SecretKey
+	rand    io.Reader
 }
 
 func (t *sessionTicket) Data() (data []byte, err error) {
@@ -23,18 +29,41 @@ func (t *sessionTicket) Data() (data []byte, err error) {
 		return nil, err
 	}
 	data = make([]byte, 4+len(ticket))
-	binary.BigEndian.PutUint32(data, t.expireTs)
+	binary.BigEndian.PutUint32(data, t.session.ExpireTs)
 	copy(data[4:], ticket) // expireTs+ticket
 	return
 }
 
+// ID 票据ID
+func (t *sessionTicket) ID() ID {
+	return t.session.ID
+}
+
 // 加密，且只有服务端才能解密
+// 明文: [format:1][suite:1][issueTs:4][expireTs:4][id:16][identityLen:1][identity]
+// [tenantLen:1][tenant][appDataLen:2][appData][ticketKey]
 func (t *sessionTicket) encrypt() ([]byte, error) {
-	data := make([]byte, 4, 4+len(t.ticketKey))
-	binary.BigEndian.PutUint32(data, t.expireTs)
-	data = append(data, t.ticketKey...)
+	se := &t.session
+	if len(se.Identity) > 0xff || len(se.Tenant) > 0xff || len(se.AppData) > 0xffff {
+		return nil, ErrTicketIllegal
+	}
+	data := make([]byte, 0, 10+len(se.ID)+1+len(se.Identity)+1+len(se.Tenant)+2+len(se.AppData)+len(se.TicketKey))
+	data = append(data, ticketFormat, se.CipherSuite)
+	data = binary.BigEndian.AppendUint32(data, se.IssueTs)
+	data = binary.BigEndian.AppendUint32(data, se.ExpireTs)
+	data = append(data, se.ID[:]...)
+	data = append(data, uint8(len(se.Identity)))
+	data = append(data, se.Identity...)
+	data = append(data, uint8(len(se.Tenant)))
+	data = append(data, se.Tenant...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(se.AppData)))
+	data = append(data, se.AppData...)
+	data = append(data, se.TicketKey...)
 
-	nonce := util.Random(22)
+	nonce, err := util.RandomFrom(t.rand, 22)
+	if err != nil {
+		return nil, err
+	}
 	nonce = binary.BigEndian.AppendUint16(nonce, t.version)
 	encrypted := secretbox.Seal(nonce[:], data, (*[24]byte)(nonce), &t.secret)
 	return encrypted, nil
@@ -51,11 +80,48 @@ func (t *sessionTicket) decrypt(data []byte) (err error) {
 	if !ok {
 		return ErrTicketIllegal
 	}
-	t.expireTs = binary.BigEndian.Uint32(decrypted[:4])
-	t.ticketKey = decrypted[4:]
+	se := &t.session
+	if len(decrypted) < 10+len(se.ID) || decrypted[0] != ticketFormat {
+		return ErrTicketIllegal
+	}
+	se.CipherSuite = decrypted[1]
+	se.IssueTs = binary.BigEndian.Uint32(decrypted[2:])
+	se.ExpireTs = binary.BigEndian.Uint32(decrypted[6:])
+	decrypted = decrypted[10:]
+	copy(se.ID[:], decrypted)
+	decrypted = decrypted[len(se.ID):]
+
+	var identity, tenant []byte
+	if identity, decrypted, ok = readVector8(decrypted); !ok {
+		return ErrTicketIllegal
+	}
+	if tenant, decrypted, ok = readVector8(decrypted); !ok {
+		return ErrTicketIllegal
+	}
+	if len(decrypted) < 2 {
+		return ErrTicketIllegal
+	}
+	appDataLen := int(binary.BigEndian.Uint16(decrypted))
+	if len(decrypted) < 2+appDataLen {
+		return ErrTicketIllegal
+	}
+	se.Identity, se.Tenant = string(identity), string(tenant)
+	if appDataLen > 0 {
+		se.AppData = decrypted[2 : 2+appDataLen]
+	}
+	se.TicketKey = decrypted[2+appDataLen:]
 	return
 }
 
+// readVector8 读取[len:1][data]
+func readVector8(data []byte) (vec, rest []byte, ok bool) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, nil, false
+	}
+	n := 1 + int(data[0])
+	return data[1:n], data[n:], true
+}
+
 func matchTicketVer(data []byte) (t *sessionTicket, err error) {
 	if len(data) < 24 {
 		return nil, ErrTicketIllegal
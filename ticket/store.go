@@ -0,0 +1,115 @@
+package ticket
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ryanx-sir/simple-als/util"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore 服务端保存会话状态，客户端只持有随机ID，可替代Encoder的无状态票据
+type SessionStore interface {
+	Put(session *Session) error
+	Get(id ID) (*Session, error)
+	// Take 读取并删除会话，用于单次使用票据，并发调用同一ID时只有一个成功
+	Take(id ID) (*Session, error)
+	Delete(id ID)
+}
+
+// MemoryStore 进程内LRU会话缓存，超过容量淘汰最久未使用的会话，过期会话读取时清除
+type MemoryStore struct {
+	Clock util.Clock // 默认系统时间
+
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // front最近使用
+	items    map[ID]*list.Element
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		panic("session store capacity must be positive")
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[ID]*list.Element),
+	}
+}
+
+func (m *MemoryStore) Put(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.items[session.ID]; ok {
+		elem.Value = session
+		m.lru.MoveToFront(elem)
+		return nil
+	}
+	m.items[session.ID] = m.lru.PushFront(session)
+	for m.lru.Len() > m.capacity {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+func (m *MemoryStore) Get(id ID) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.items[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	session := elem.Value.(*Session)
+	if session.ExpireTs < uint32(m.now().Unix()) {
+		m.remove(elem)
+		return nil, ErrSessionNotFound
+	}
+	m.lru.MoveToFront(elem)
+	return session, nil
+}
+
+func (m *MemoryStore) Take(id ID) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.items[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	m.remove(elem)
+	session := elem.Value.(*Session)
+	if session.ExpireTs < uint32(m.now().Unix()) {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (m *MemoryStore) Delete(id ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.items[id]; ok {
+		m.remove(elem)
+	}
+}
+
+// Len 当前缓存的会话数
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *MemoryStore) now() time.Time {
+	if m.Clock == nil {
+		return time.Now()
+	}
+	return m.Clock.Now()
+}
+
+func (m *MemoryStore) remove(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.items, elem.Value.(*Session).ID)
+}
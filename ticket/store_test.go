@@ -0,0 +1,58 @@
+package ticket
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MemoryStore(t *testing.T) {
+	store := NewMemoryStore(2)
+	nowTs := uint32(time.Now().Unix())
+	sessions := []*Session{
+		{ID: ID{1}, ExpireTs: nowTs + 60},
+		{ID: ID{2}, ExpireTs: nowTs + 60},
+		{ID: ID{3}, ExpireTs: nowTs - 1},
+	}
+	store.Put(sessions[0])
+	store.Put(sessions[1])
+	store.Get(sessions[0].ID) // 0最近使用，2淘汰1
+	store.Put(sessions[2])
+
+	if _, err := store.Get(sessions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(sessions[1].ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("want evicted", err)
+	}
+	if _, err := store.Get(sessions[2].ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("want expired", err)
+	}
+	if store.Len() != 1 {
+		t.Fatal("unexpected len", store.Len())
+	}
+}
+
+func Test_MemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore(10)
+	session := &Session{ID: ID{1}, ExpireTs: uint32(time.Now().Unix()) + 60}
+	store.Put(session)
+
+	var wg sync.WaitGroup
+	var taken atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Take(session.ID); err == nil {
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if taken.Load() != 1 || store.Len() != 0 {
+		t.Fatal("want exactly one take", taken.Load(), store.Len())
+	}
+}
@@ -0,0 +1,64 @@
+package wdals
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ryanx-sir/simple-als/client"
+)
+
+// Transport 实现http.RoundTripper，请求序列化后作为PSK early data加密发送
+// 服务端需使用Middleware，替换http.Client.Transport即可接入
+// Client可并发使用，请求之间不串行；Client实现RequestContext时req.Context()取消后中止请求
+// 票据被拒绝时只有幂等方法的请求自动重发一次，其他请求返回ErrTicketRejected；
+// Client配置了RetryRejected时由Client重发，不再重复重发
+type Transport struct {
+	Client AlClient
+}
+
+// NewTransport host为协议endpoint，如 http://127.0.0.1:20000/wdals
+func NewTransport(host string, config *ClientConfig) (*Transport, error) {
+	c, err := NewClient(host, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{Client: c}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	data, err := t.request(req.Context(), buf.Bytes())
+	if errors.Is(err, client.ErrTicketRejected) && idempotent(req.Method) && !t.clientRetries() {
+		data, err = t.request(req.Context(), buf.Bytes()) // 票据已清除，重新握手后发送
+	}
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+}
+
+// request Client实现RequestContext时传入ctx，否则只在发送前检查ctx
+func (t *Transport) request(ctx context.Context, data []byte) ([]byte, error) {
+	if c, ok := t.Client.(interface {
+		RequestContext(ctx context.Context, data []byte) ([]byte, error)
+	}); ok {
+		return c.RequestContext(ctx, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.Client.Request(data)
+}
+
+// clientRetries Client配置了RetryRejected时已自行重发
+func (t *Transport) clientRetries() bool {
+	c, ok := t.Client.(interface{ RetryRejected() bool })
+	return ok && c.RetryRejected()
+}
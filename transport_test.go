@@ -0,0 +1,113 @@
+package wdals
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ryanx-sir/simple-als/client"
+)
+
+func Test_Transport(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		io.Copy(w, r.Body)
+	})
+	hs := newTestMiddleware(t, api)
+	defer hs.Close()
+
+	transport, err := NewTransport(hs.URL+"/wdals", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &http.Client{Transport: transport}
+	for _, body := range []string{"first", strings.Repeat("large body ", 5000)} {
+		resp, err := hc.Post("http://api.local/echo", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("X-Method") != http.MethodPost || string(echo) != body {
+			t.Fatal("unexpected response", resp.Header, len(echo))
+		}
+	}
+}
+
+// rejectOnceClient 第一次请求返回ErrTicketRejected，之后回显
+type rejectOnceClient struct {
+	AlClient
+	requests int
+}
+
+func (c *rejectOnceClient) Request(data []byte) ([]byte, error) {
+	if c.requests++; c.requests == 1 {
+		return nil, client.ErrTicketRejected
+	}
+	return []byte("HTTP/1.1 204 No Content\r\n\r\n"), nil
+}
+
+func Test_TransportTicketRejected(t *testing.T) {
+	// 只有幂等方法自动重发
+	for method, want := range map[string]int{http.MethodGet: 2, http.MethodPost: 1} {
+		c := &rejectOnceClient{}
+		req, _ := http.NewRequest(method, "http://api.local/orders", nil)
+		resp, err := (&Transport{Client: c}).RoundTrip(req)
+		if c.requests != want {
+			t.Fatal(method, "unexpected requests", c.requests)
+		}
+		if method == http.MethodPost {
+			if !errors.Is(err, client.ErrTicketRejected) {
+				t.Fatal("want ticket rejected", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
+
+// retryingClient 配置了RetryRejected的Client，自行重发后仍被拒绝
+type retryingClient struct {
+	rejectOnceClient
+}
+
+func (c *retryingClient) Request([]byte) ([]byte, error) {
+	c.requests++
+	return nil, client.ErrTicketRejected
+}
+
+func (c *retryingClient) RetryRejected() bool {
+	return true
+}
+
+func Test_TransportClientRetry(t *testing.T) {
+	c := &retryingClient{}
+	req, _ := http.NewRequest(http.MethodGet, "http://api.local/orders", nil)
+	if _, err := (&Transport{Client: c}).RoundTrip(req); !errors.Is(err, client.ErrTicketRejected) {
+		t.Fatal("want ticket rejected", err)
+	}
+	if c.requests != 1 {
+		t.Fatal("retried on top of client retry", c.requests)
+	}
+}
+
+func Test_TransportContext(t *testing.T) {
+	hs := newTestMiddleware(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hs.Close()
+	transport, err := NewTransport(hs.URL+"/wdals", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://api.local/orders", nil)
+	if _, err = transport.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Fatal("want context.Canceled", err)
+	}
+}
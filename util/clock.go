@@ -0,0 +1,17 @@
+package util
+
+import "time"
+
+// Clock 时间源，测试时可注入固定时间
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock 系统时间
+var SystemClock Clock = systemClock{}
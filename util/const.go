@@ -7,8 +7,29 @@ const (
 	PSK_WITH_XSALSA20_POLY1305        uint8 = 0xcc
 )
 
+// SupportedSuite 是否为已定义的密码套件
+func SupportedSuite(suite uint8) bool {
+	return suite >= DHE_SECP256R1_WITH_AES_GCM && suite <= PSK_WITH_XSALSA20_POLY1305
+}
+
+// ResumptionSuite ECDHE套件签发的票据对应的PSK恢复套件，PSK套件返回自身
+func ResumptionSuite(suite uint8) uint8 {
+	switch suite {
+	case DHE_SECP256R1_WITH_AES_GCM:
+		return PSK_WITH_AES_GCM
+	case DHE_X25519_WITH_XSALSA20_POLY1305:
+		return PSK_WITH_XSALSA20_POLY1305
+	}
+	return suite
+}
+
 const (
 	EarlyKdf  = "the early kdf key"
 	MasterKdf = "the master kdf key"
 	TicketKdf = "the ticket kdf key"
+
+	ClientTrafficKdf = "the client traffic kdf key"
+	ServerTrafficKdf = "the server traffic kdf key"
+
+	ServerSignContext = "the server signature"
 )
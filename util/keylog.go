@@ -0,0 +1,33 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// key log 标签，格式参考NSS SSLKEYLOGFILE: <label> <clientNonce hex> <secret hex>
+const (
+	KeyLogMaster = "CLIENT_RANDOM"
+	KeyLogEarly  = "CLIENT_EARLY_TRAFFIC_SECRET"
+	KeyLogTicket = "TICKET_SECRET"
+
+	KeyLogClientTraffic = "CLIENT_TRAFFIC_SECRET_0"
+	KeyLogServerTraffic = "SERVER_TRAFFIC_SECRET_0"
+)
+
+var keyLogMutex sync.Mutex
+
+// WriteKeyLog 输出一行key log，w为nil时不输出
+// 仅用于调试时解密抓包，生产环境不要配置
+func WriteKeyLog(w io.Writer, label string, clientNonce, secret []byte) error {
+	if w == nil {
+		return nil
+	}
+	line := fmt.Sprintf("%s %x %x\n", label, clientNonce, secret)
+
+	keyLogMutex.Lock()
+	defer keyLogMutex.Unlock()
+	_, err := w.Write([]byte(line))
+	return err
+}
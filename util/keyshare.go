@@ -0,0 +1,51 @@
+package util
+
+import (
+	"crypto/ecdh"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// KeyShare PSK_DHE模式的临时密钥，AES-GCM套件使用P-256，XSalsa20-Poly1305套件使用X25519
+type KeyShare struct {
+	Public []byte
+
+	p256   *ecdh.PrivateKey
+	x25519 *[32]byte
+}
+
+// NewKeyShare 按PSK套件从随机源生成临时密钥
+func NewKeyShare(r io.Reader, suite uint8) (*KeyShare, error) {
+	if suite == PSK_WITH_XSALSA20_POLY1305 {
+		publicKey, privateKey, err := GenerateX25519Key(r)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyShare{Public: publicKey[:], x25519: privateKey}, nil
+	}
+	privateKey, err := GenerateP256Key(r)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyShare{Public: privateKey.PublicKey().Bytes(), p256: privateKey}, nil
+}
+
+// Shared 与对端公钥计算ECDHE共享密钥
+func (k *KeyShare) Shared(peer []byte) ([]byte, error) {
+	if k.x25519 != nil {
+		return curve25519.X25519(k.x25519[:], peer)
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	return k.p256.ECDH(publicKey)
+}
+
+// PskDheSecret 票据密钥与ECDHE共享密钥拼接，作为主密钥和新票据密钥的派生输入
+func PskDheSecret(ticketKey, shared []byte) []byte {
+	secret := make([]byte, 0, len(ticketKey)+len(shared))
+	secret = append(secret, ticketKey...)
+	return append(secret, shared...)
+}
@@ -0,0 +1,18 @@
+package util
+
+import "time"
+
+// HandshakeEvent 一次握手或请求的结果，不包含任何密钥
+type HandshakeEvent struct {
+	CipherSuite uint8
+	ServerName  string
+	Resumed     bool // PSK恢复
+	Retry       bool // 服务端回复了HelloRetry
+	Duration    time.Duration
+	Err         error
+}
+
+// Observer 握手事件回调，用于监控统计，需并发安全
+type Observer interface {
+	ObserveHandshake(e HandshakeEvent)
+}
@@ -3,9 +3,13 @@ package util
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"golang.org/x/crypto/curve25519"
+	"io"
 )
 
 var ErrDataCorrupted = errors.New("data corrupted")
@@ -20,10 +24,49 @@ func XorNonce(nonce []byte, seq uint32) {
 	}
 }
 
-func Random(n int) []byte {
+// SignedContent 服务端身份签名内容: [context][sha256(ClientHello)][服务端临时公钥]
+func SignedContent(clientHello, serverKey []byte) []byte {
+	helloHash := sha256.Sum256(clientHello)
+	content := append([]byte(ServerSignContext), helloHash[:]...)
+	return append(content, serverKey...)
+}
+
+func Random(n int) ([]byte, error) {
+	return RandomFrom(rand.Reader, n)
+}
+
+// RandomFrom 从指定随机源读取n字节，注入的随机源可能读取失败
+func RandomFrom(r io.Reader, n int) ([]byte, error) {
 	key := make([]byte, n)
-	rand.Read(key)
-	return key
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GenerateP256Key 从随机源生成P-256私钥，只消耗随机源读取的字节，结果可复现
+func GenerateP256Key(r io.Reader) (*ecdh.PrivateKey, error) {
+	for i := 0; i < 8; i++ {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		if privateKey, err := ecdh.P256().NewPrivateKey(key); err == nil {
+			return privateKey, nil
+		}
+	}
+	return nil, errors.New("generate p256 key failed")
+}
+
+// GenerateX25519Key 从随机源生成X25519密钥对
+func GenerateX25519Key(r io.Reader) (publicKey, privateKey *[32]byte, err error) {
+	privateKey = new([32]byte)
+	if _, err = io.ReadFull(r, privateKey[:]); err != nil {
+		return nil, nil, err
+	}
+	publicKey = new([32]byte)
+	curve25519.ScalarBaseMult(publicKey, privateKey)
+	return
 }
 
 func AesGcmDecrypt(key, nonce, input, additional []byte) ([]byte, error) {
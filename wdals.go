@@ -19,16 +19,74 @@ type Server interface {
 	Handle(io.Reader) ([]byte, error)
 }
 
+// StreamServer 流式PSK请求的响应边处理边写入w，NewServer返回的Server均实现
+type StreamServer interface {
+	Server
+	HandleStream(r io.Reader, w io.Writer) error
+}
+
 // 应用层client
 type AlClient interface {
 	Handshake() error
 	Request([]byte) ([]byte, error)
+	// RequestStream 流式请求，响应被截断时读取返回io.ErrUnexpectedEOF
+	RequestStream(body io.Reader) (io.ReadCloser, error)
+	NeedHandshake() bool // 无可用票据或票据即将过期
+}
+
+type ServerConfig = server.Config
+type ClientConfig = client.Config
+type ClientTransport = client.Transport
+type HTTPTransport = client.HTTPTransport
+type ClientSession = client.ClientSession
+type ClientSessionCache = client.ClientSessionCache
+type RetryConfig = server.RetryConfig
+type ClientHelloInfo = server.ClientHelloInfo
+type Peer = server.Peer
+
+// 监控与注入
+type Clock = util.Clock
+type Observer = util.Observer
+type HandshakeEvent = util.HandshakeEvent
+
+// 应用层handler，处理解密后的early data
+type Handler = server.Handler
+type HandlerFunc = server.HandlerFunc
+type Request = server.Request
+type StreamHandler = server.StreamHandler
+type StreamHandlerFunc = server.StreamHandlerFunc
+type StreamRequest = server.StreamRequest
+
+// NewServer 校验配置并创建服务端
+func NewServer(config *ServerConfig) (Server, error) {
+	return server.NewServer(config)
 }
 
+// NewSimpleServer ticketEncoder为nil时panic
 func NewSimpleServer(ticketEncoder *ticket.Encoder) Server {
-	return server.NewServer(ticketEncoder)
+	s, err := NewServer(&ServerConfig{TicketEncoder: ticketEncoder})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewClient 校验配置并创建客户端，host为endpoint地址，按CipherSuites选择密码套件
+func NewClient(host string, config *ClientConfig) (AlClient, error) {
+	return client.NewClient(host, config)
 }
 
 func NewAesGcmClient(host string) AlClient {
-	return client.NewAesGcmClient(host)
-}
\ No newline at end of file
+	c, _ := NewClient(host, nil) // 默认配置不会校验失败
+	return c
+}
+
+func NewNaclClient(host string) AlClient {
+	c, _ := NewClient(host, &ClientConfig{CipherSuites: []uint8{DHE_X25519_WITH_XSALSA20_POLY1305}})
+	return c
+}
+
+// NewMemoryTransport 客户端直接调用进程内的服务端，用于测试
+func NewMemoryTransport(srv Server) ClientTransport {
+	return &client.MemoryTransport{Server: srv}
+}